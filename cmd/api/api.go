@@ -12,8 +12,14 @@ import (
 	"time"
 
 	"github.com/Alter-Sitanshu/learning_Go/internal/auth"
+	"github.com/Alter-Sitanshu/learning_Go/internal/cache"
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
 	"github.com/Alter-Sitanshu/learning_Go/internal/database"
 	"github.com/Alter-Sitanshu/learning_Go/internal/mailer"
+	"github.com/Alter-Sitanshu/learning_Go/internal/storage"
+	"github.com/Alter-Sitanshu/learning_Go/internal/stream"
+	"github.com/Alter-Sitanshu/learning_Go/internal/unfurl"
+	"github.com/Alter-Sitanshu/learning_Go/internal/worker"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -23,6 +29,19 @@ type Application struct {
 	store         database.Storage
 	mailer        *mailer.SMTPSender
 	authenticator *auth.Authenticator
+	hub           *stream.Hub
+	cursors       *cursor.Codec
+	workers       *worker.Pool
+	// mediaWorkers resize uploaded images, apart from the quick tasks
+	mediaWorkers *worker.Pool
+	// explore keeps the explore pages served to anonymous users
+	explore *cache.Cache[[]database.Feed]
+	// syndication keeps the rendered RSS and Atom feeds
+	syndication *cache.Cache[*syndicated]
+	// blobs keeps the content of uploaded media
+	blobs storage.BlobStore
+	// unfurler fetches the previews of the links of posts
+	unfurler *unfurl.Fetcher
 }
 
 type Config struct {
@@ -30,6 +49,29 @@ type Config struct {
 	db   DBConfig
 	mail mailer.SMTPConfig
 	auth BasicAuthConfig
+	// deletionGrace is how long a deactivated account can still be restored
+	deletionGrace time.Duration
+	// apiURL is the public address of the API, used in emailed links
+	apiURL string
+	// wsOrigins are the web origins allowed to open the WebSocket gateway
+	wsOrigins []string
+	export    ExportConfig
+	// ranking weighs the signals of the top and relevant feeds
+	ranking database.RankWeights
+	media   MediaConfig
+}
+
+// MediaConfig selects where uploads are kept: the local directory dir, or
+// the bucket of s3 when store is "s3".
+type MediaConfig struct {
+	store string
+	dir   string
+	s3    storage.S3Config
+}
+
+type ExportConfig struct {
+	dir    string
+	expiry time.Duration
 }
 
 type BasicAuthConfig struct {
@@ -59,49 +101,143 @@ func (app *Application) mount() http.Handler {
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recoverer)
 
-	// Set a timeout value on the request context (ctx), that will signal
-	// through ctx.Done() that the request has timed out and further
-	// processing should be stopped.
-	router.Use(middleware.Timeout(60 * time.Second))
-
 	// Routes for the API
 	router.Route("/v1", func(r chi.Router) {
-		r.With(app.BasicAuthMiddleware()).Get("/health", app.HealthCheck)
-
-		r.Route("/post", func(r chi.Router) {
-			r.Use(app.AuthorizationMiddleware)
-			r.Post("/", app.CreatPostHandler)
-			r.Route("/{id}", func(r chi.Router) {
-				// MIDDLEWARE TO ACCESS THE ID AND FETCH POST
-				r.Use(app.PostMiddleware)
-
-				r.Get("/", app.GetPostHandler)
-				r.Delete("/", app.checkRoleMiddleware("admin", app.DeletePostHandler))
-				r.Patch("/", app.checkRoleMiddleware("moderator", app.UpdatePostHandler))
-				r.Post("/comment", app.CreateCommentHandler)
+		// The event stream and the WebSocket gateway are long lived, they
+		// are kept out of the request timeout below and manage their own
+		// write deadlines.
+		r.With(app.AuthorizationMiddleware).Get("/stream", app.StreamHandler)
+		r.With(WSTokenMiddleware, app.AuthorizationMiddleware).Get("/ws", app.WebSocketHandler)
+
+		r.Group(func(r chi.Router) {
+			// Set a timeout value on the request context (ctx), that will signal
+			// through ctx.Done() that the request has timed out and further
+			// processing should be stopped.
+			r.Use(middleware.Timeout(60 * time.Second))
+
+			r.With(app.BasicAuthMiddleware()).Get("/health", app.HealthCheck)
+
+			r.Route("/media", func(r chi.Router) {
+				r.With(app.AuthorizationMiddleware).Post("/", app.UploadMediaHandler)
+				// the media of public posts are served to feed readers too
+				r.With(app.OptionalAuthMiddleware).Get("/{mediaID}", app.GetMediaHandler)
+				r.With(app.OptionalAuthMiddleware).Get("/{mediaID}/{variant}", app.GetMediaVariantHandler)
 			})
+			r.Route("/post", func(r chi.Router) {
+				r.Use(app.AuthorizationMiddleware)
+				r.Post("/", app.CreatPostHandler)
+				r.Route("/drafts", func(r chi.Router) {
+					r.Get("/", app.GetDraftsHandler)
+					r.With(app.PostMiddleware, app.PostOwnerMiddleware).Patch("/{id}", app.UpdateDraftHandler)
+				})
+				r.Route("/{id}", func(r chi.Router) {
+					// MIDDLEWARE TO ACCESS THE ID AND FETCH POST
+					r.Use(app.PostMiddleware)
+
+					r.Group(func(r chi.Router) {
+						r.Use(app.PublishedMiddleware)
+
+						r.Put("/repost", app.RepostHandler)
+						r.Delete("/repost", app.UndoRepostHandler)
+						r.Put("/reactions", app.ReactHandler)
+						r.Delete("/reactions", app.UnreactHandler)
+						r.Post("/comment", app.CreateCommentHandler)
+					})
+
+					r.Group(func(r chi.Router) {
+						r.Use(app.PostOwnerMiddleware)
+
+						r.Get("/", app.GetPostHandler)
+						r.Delete("/", app.checkRoleMiddleware("admin", app.DeletePostHandler))
+						r.Patch("/", app.checkRoleMiddleware("moderator", app.UpdatePostHandler))
+					})
+				})
 
-		})
-		r.Route("/users", func(r chi.Router) {
-			// User Middleware
-			r.Use(app.AuthorizationMiddleware)
-			r.Route("/{userID}", func(r chi.Router) {
-
-				r.Get("/", app.GetUserHandler)
-				r.Put("/follow", app.FollowUser)
-				r.Put("/unfollow", app.UnfollowUser)
-				r.Delete("/", app.DeleteUserHandler)
 			})
-			r.Group(func(r chi.Router) {
-				r.Get("/feed", app.GetFeedHandler)
+			r.Route("/users", func(r chi.Router) {
+				// User Middleware
+				r.Use(app.AuthorizationMiddleware)
+				r.Route("/me", func(r chi.Router) {
+					r.Patch("/", app.UpdateProfileHandler)
+					r.Put("/password", app.ChangePasswordHandler)
+					r.Post("/email", app.ChangeEmailHandler)
+					r.Route("/follow-requests", func(r chi.Router) {
+						r.Get("/", app.GetFollowRequestsHandler)
+						r.Put("/{userID}", app.ApproveFollowRequestHandler)
+						r.Delete("/{userID}", app.RejectFollowRequestHandler)
+					})
+					r.Get("/blocks", app.GetBlockedHandler)
+					r.Post("/export", app.RequestExportHandler)
+					r.Get("/mutes", app.GetMutedHandler)
+				})
+				r.Route("/{userID}", func(r chi.Router) {
+
+					r.Get("/", app.GetUserHandler)
+					r.Put("/follow", app.FollowUser)
+					r.Put("/unfollow", app.UnfollowUser)
+					r.Get("/followers", app.GetFollowersHandler)
+					r.Get("/following", app.GetFollowingHandler)
+					r.Put("/block", app.BlockUserHandler)
+					r.Delete("/block", app.UnblockUserHandler)
+					r.Put("/mute", app.MuteUserHandler)
+					r.Delete("/mute", app.UnmuteUserHandler)
+					r.Put("/suspend", app.requireRoleMiddleware("moderator", app.SuspendUserHandler))
+					r.Delete("/suspend", app.requireRoleMiddleware("moderator", app.UnsuspendUserHandler))
+					r.Delete("/", app.DeleteUserHandler)
+				})
+				r.Group(func(r chi.Router) {
+					r.Get("/feed", app.GetFeedHandler)
+					r.Get("/search", app.SearchUsersHandler)
+					r.Get("/suggestions", app.GetSuggestionsHandler)
+				})
+			})
+			r.With(app.OptionalAuthMiddleware).Get("/explore", app.ExploreHandler)
+			// Feeds of public posts for feed readers, which do not authenticate
+			r.Get("/users/{userID}/feed.{format:rss|atom}", app.UserSyndicationHandler)
+			r.Get("/tags/{tag}/feed.{format:rss|atom}", app.TagSyndicationHandler)
+			r.Route("/tags", func(r chi.Router) {
+				r.Use(app.AuthorizationMiddleware)
+				r.Get("/trending", app.GetTrendingTagsHandler)
+				r.Get("/following", app.GetFollowedTagsHandler)
+				r.Route("/{tag}", func(r chi.Router) {
+					r.Get("/posts", app.GetTagPostsHandler)
+					r.Put("/follow", app.FollowTagHandler)
+					r.Delete("/follow", app.UnfollowTagHandler)
+				})
+			})
+			r.Route("/search", func(r chi.Router) {
+				r.Use(app.AuthorizationMiddleware)
+				r.Get("/posts", app.SearchPostsHandler)
+			})
+			// the token of the link mailed to the user authorises the download
+			r.Get("/exports/{token}", app.DownloadExportHandler)
+			r.Route("/notifications", func(r chi.Router) {
+				r.Use(app.AuthorizationMiddleware)
+				r.Get("/", app.GetNotificationsHandler)
+				r.Put("/read", app.MarkAllNotificationsReadHandler)
+				r.Put("/{notificationID}/read", app.MarkNotificationReadHandler)
+				r.Get("/preferences", app.GetNotificationPreferencesHandler)
+				r.Put("/preferences", app.SetNotificationPreferencesHandler)
+			})
+			r.Route("/conversations", func(r chi.Router) {
+				r.Use(app.AuthorizationMiddleware)
+				r.Get("/", app.GetConversationsHandler)
+				r.Post("/", app.CreateConversationHandler)
+				r.Put("/settings", app.DMSettingsHandler)
+				r.Route("/{conversationID}", func(r chi.Router) {
+					r.Use(app.ConversationMiddleware)
+					r.Get("/messages", app.GetMessagesHandler)
+					r.Post("/messages", app.SendMessageHandler)
+					r.Put("/read", app.MarkConversationReadHandler)
+				})
+			})
+			r.Route("/auth", func(r chi.Router) {
+				r.Post("/token", app.JWTHandler)
+				r.Put("/activate/{token}", app.ActivateUserHandler)
+				r.Put("/email/{token}", app.ConfirmEmailHandler)
+				r.Post("/user", app.CreateUserHandler)
 			})
 		})
-		r.Route("/auth", func(r chi.Router) {
-			r.Post("/token", app.JWTHandler)
-			r.Put("/activate/{token}", app.ActivateUserHandler)
-			r.Post("/user", app.CreateUserHandler)
-		})
-
 	})
 
 	return router
@@ -115,6 +251,8 @@ func (app *Application) run(mux http.Handler) error {
 		ReadTimeout:  time.Second * 10,
 		IdleTimeout:  time.Minute,
 	}
+	// Event streams never go idle, close them so that shutdown can complete
+	server.RegisterOnShutdown(app.hub.Reset)
 
 	// graceful shutdown
 	shutdown := make(chan error)
@@ -41,6 +41,31 @@ func (app *Application) ActivateUserHandler(w http.ResponseWriter, r *http.Reque
 	jsonResponse(w, status, res)
 }
 
+func (app *Application) ConfirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	status := http.StatusOK
+	res := Response{
+		Message: "email changed",
+	}
+
+	err := app.store.User().ConfirmEmailChange(r.Context(), token, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrTokenExpired):
+			res.Message = "Invalid Token/ Expired Token"
+			status = http.StatusBadRequest
+		case errors.Is(err, database.ErrDupliMail):
+			res.Message = "email already exists"
+			status = http.StatusBadRequest
+		default:
+			res.Message = "server error"
+			status = http.StatusInternalServerError
+		}
+		log.Printf("server error: %v\n", err.Error())
+	}
+	jsonResponse(w, status, res)
+}
+
 func (app *Application) JWTHandler(w http.ResponseWriter, r *http.Request) {
 	// parse the payload and get the user from it
 	// encode the creds into the token with claims
@@ -78,6 +103,23 @@ func (app *Application) JWTHandler(w http.ResponseWriter, r *http.Request) {
 		jsonResponse(w, http.StatusUnauthorized, res)
 		return
 	}
+	// Logging in cancels the deletion of a deactivated account
+	if user.DeactivatedAt != nil {
+		since := time.Now().Add(-app.config.deletionGrace)
+		err = app.store.User().ReactivateUser(r.Context(), user, since)
+		if err != nil {
+			switch err {
+			case database.ErrNotFound:
+				res.Message = "invalid credentials"
+				jsonResponse(w, http.StatusUnauthorized, res)
+			default:
+				log.Printf("DB error: %v\n", err.Error())
+				res.Message = "server error"
+				jsonResponse(w, http.StatusInternalServerError, res)
+			}
+			return
+		}
+	}
 	claims := jwt.MapClaims{
 		"sub": user.ID,
 		"iss": "GOSocial",
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+)
+
+func (app *Application) BlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.updateBlockList(w, r, app.store.Block().Block, "User blocked")
+}
+
+func (app *Application) UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.updateBlockList(w, r, app.store.Block().Unblock, "User unblocked")
+}
+
+func (app *Application) MuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.updateBlockList(w, r, app.store.Block().Mute, "User muted")
+}
+
+func (app *Application) UnmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.updateBlockList(w, r, app.store.Block().Unmute, "User unmuted")
+}
+
+func (app *Application) GetBlockedHandler(w http.ResponseWriter, r *http.Request) {
+	app.listBlockList(w, r, "blocked", app.store.Block().GetBlocked)
+}
+
+func (app *Application) GetMutedHandler(w http.ResponseWriter, r *http.Request) {
+	app.listBlockList(w, r, "muted", app.store.Block().GetMuted)
+}
+
+// updateBlockList adds the user of the URL to, or removes them from, the
+// block or mute list of the current user.
+func (app *Application) updateBlockList(w http.ResponseWriter, r *http.Request,
+	update func(context.Context, int64, int64) error, message string) {
+	user := getUserFromCtx(r)
+	res := Response{}
+	targetID, err := parseUserID(r)
+	if err != nil {
+		res.Message = "id should only contain integers."
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	if err := update(r.Context(), user.ID, targetID); err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			res.Message = "User not found"
+			jsonResponse(w, http.StatusNotFound, res)
+		case errors.Is(err, database.ErrSelfBlock):
+			res.Message = err.Error()
+			jsonResponse(w, http.StatusBadRequest, res)
+		default:
+			log.Printf("DB error: %v\n", err.Error())
+			res.Message = "Server error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+		}
+		return
+	}
+
+	res.Message = message
+	jsonResponse(w, http.StatusOK, res)
+}
+
+type blockLister func(context.Context, int64, cursor.Key, int) ([]database.BlockEntry, error)
+
+func (app *Application) listBlockList(w http.ResponseWriter, r *http.Request, name string, list blockLister) {
+	user := getUserFromCtx(r)
+	res := Response{}
+	page, err := app.parsePage(r, name, 20, 100)
+	if err != nil {
+		res.Message = "Bad request: " + err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	entries, err := list(r.Context(), user.ID, page.After, page.Limit)
+	if err != nil {
+		log.Printf("DB error: %v\n", err.Error())
+		res.Message = "Server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	var next string
+	if n := len(entries); n > 0 {
+		last := entries[n-1]
+		next = page.nextCursor(n, cursor.Key{Time: last.CreatedAt, ID: last.ID})
+	}
+	paginatedResponse(w, http.StatusOK, entries, next, "")
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+	"github.com/Alter-Sitanshu/learning_Go/internal/mailer"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// exportBatch is how many exports a run of the export job builds.
+const exportBatch = 5
+
+func (app *Application) RequestExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	res := Response{}
+
+	export, err := app.store.Export().Request(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrExportInProgress):
+			res.Message = err.Error()
+			jsonResponse(w, http.StatusConflict, res)
+		default:
+			log.Printf("DB error: %v\n", err.Error())
+			res.Message = "server error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+		}
+		return
+	}
+
+	jsonResponse(w, http.StatusAccepted, export)
+}
+
+func (app *Application) DownloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	res := Response{}
+
+	export, err := app.store.Export().GetByToken(r.Context(), token, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			res.Message = "invalid or expired link"
+			jsonResponse(w, http.StatusNotFound, res)
+		default:
+			log.Printf("DB error: %v\n", err.Error())
+			res.Message = "server error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+		}
+		return
+	}
+
+	f, err := os.Open(export.Path)
+	if err != nil {
+		log.Printf("export %d: %v\n", export.ID, err.Error())
+		res.Message = "server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+	defer f.Close()
+
+	name := filepath.Base(export.Path)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, export.CreatedAt, f)
+}
+
+// buildExports builds the archives of the pending exports and mails their
+// download links.
+func (app *Application) buildExports(ctx context.Context) error {
+	exports, err := app.store.Export().Claim(ctx, exportBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		if err := app.buildExport(ctx, &export); err != nil {
+			log.Printf("export %d: %v\n", export.ID, err.Error())
+			if err := app.store.Export().Fail(ctx, export.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (app *Application) buildExport(ctx context.Context, export *database.DataExport) error {
+	data, err := app.store.Export().UserData(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(app.config.export.dir, 0o700); err != nil {
+		return err
+	}
+	export.Path = filepath.Join(app.config.export.dir, fmt.Sprintf("export-%d-%d.zip", export.UserID, export.ID))
+	if err := writeExport(export.Path, data); err != nil {
+		return err
+	}
+
+	plainToken := uuid.New().String()
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	expiry := time.Now().Add(app.config.export.expiry)
+	if err := app.store.Export().Complete(ctx, export, hashToken, expiry); err != nil {
+		os.Remove(export.Path)
+		return err
+	}
+
+	req := mailer.EmailRequest{
+		To:      data.Profile.Email,
+		Subject: "Your data export is ready",
+		Body: fmt.Sprintf("Your data can be downloaded from: %s/v1/exports/%s\nExpires on: %s",
+			app.config.apiURL, plainToken, expiry.Format(time.RFC1123)),
+	}
+	if err := app.mailer.SendEmail(req); err != nil {
+		log.Printf("encountered error sending mail: %v\n", err.Error())
+	}
+	return nil
+}
+
+// writeExport stores data at path as a zip with one JSON file per kind of
+// record. The archive is written next to path and moved in place once
+// complete.
+func writeExport(path string, data *database.UserData) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", data.Profile},
+		{"posts.json", data.Posts},
+		{"revisions.json", data.Revisions},
+		{"comments.json", data.Comments},
+		{"followers.json", data.Followers},
+		{"following.json", data.Following},
+		{"reactions.json", data.Reactions},
+		{"notifications.json", data.Notifications},
+	}
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if err := EncodeJSON(fw, file.data); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// expireExports removes the archives whose links expired.
+func (app *Application) expireExports(ctx context.Context) error {
+	exports, err := app.store.Export().Expire(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		if export.Path == "" {
+			continue
+		}
+		if err := os.Remove(export.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("export %d: %v\n", export.ID, err.Error())
+		}
+	}
+	return nil
+}
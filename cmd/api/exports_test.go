@@ -0,0 +1,108 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+)
+
+func TestWriteExport(t *testing.T) {
+	data := &database.UserData{
+		Profile: database.User{ID: 7, Name: "ada", Email: "ada@example.com"},
+		Posts: []database.Post{
+			{ID: 1, Title: "first", Content: "edited", Format: "plain", UserID: 7, Tags: []string{"go"}, Version: 1},
+		},
+		Revisions: []database.PostRevision{
+			{PostID: 1, Version: 0, Title: "first", Content: "original", Format: "plain", Tags: []string{}},
+		},
+		Comments:      []database.Comment{{ID: 3, Postid: 1, Userid: 7, Content: "a comment"}},
+		Followers:     []database.FollowEntry{},
+		Following:     []database.FollowEntry{},
+		Reactions:     []database.Reaction{{PostID: 1, UserID: 7, Kind: "like"}},
+		Notifications: []database.Notification{},
+	}
+
+	path := filepath.Join(t.TempDir(), "export.zip")
+	if err := writeExport(path, data); err != nil {
+		t.Fatalf("writeExport: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary archive left behind: %v", err)
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	defer zr.Close()
+
+	var names []string
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		files[f.Name] = f
+	}
+	want := []string{
+		"profile.json", "posts.json", "revisions.json", "comments.json",
+		"followers.json", "following.json", "reactions.json", "notifications.json",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("archive files = %v, want %v", names, want)
+	}
+
+	read := func(name string, v any) {
+		t.Helper()
+		rc, err := files[name].Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		defer rc.Close()
+		if err := json.NewDecoder(rc).Decode(v); err != nil {
+			t.Fatalf("decode %s: %v", name, err)
+		}
+	}
+
+	var profile database.User
+	read("profile.json", &profile)
+	if profile.ID != data.Profile.ID || profile.Email != data.Profile.Email {
+		t.Errorf("profile = %+v, want %+v", profile, data.Profile)
+	}
+
+	var posts []database.Post
+	read("posts.json", &posts)
+	if len(posts) != 1 || posts[0].Content != "edited" || posts[0].Version != 1 {
+		t.Errorf("posts = %+v", posts)
+	}
+
+	var revisions []database.PostRevision
+	read("revisions.json", &revisions)
+	if !reflect.DeepEqual(revisions, data.Revisions) {
+		t.Errorf("revisions = %+v, want %+v", revisions, data.Revisions)
+	}
+
+	var comments []database.Comment
+	read("comments.json", &comments)
+	if len(comments) != 1 || comments[0].Content != "a comment" {
+		t.Errorf("comments = %+v", comments)
+	}
+
+	var reactions []database.Reaction
+	read("reactions.json", &reactions)
+	if !reflect.DeepEqual(reactions, data.Reactions) {
+		t.Errorf("reactions = %+v, want %+v", reactions, data.Reactions)
+	}
+
+	// empty listings are exported as empty arrays, not null
+	for _, name := range []string{"followers.json", "following.json", "notifications.json"} {
+		var entries []json.RawMessage
+		read(name, &entries)
+		if entries == nil || len(entries) != 0 {
+			t.Errorf("%s = %v, want []", name, entries)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+	"github.com/Alter-Sitanshu/learning_Go/internal/mailer"
+	"github.com/Alter-Sitanshu/learning_Go/internal/scheduler"
+)
+
+// singleton adapts a job that runs on one instance at a time, so that the
+// runs finding it busy elsewhere are logged as skipped.
+func singleton(run func(context.Context) error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		err := run(ctx)
+		if errors.Is(err, database.ErrLocked) {
+			return scheduler.ErrSkipped
+		}
+		return err
+	}
+}
+
+// purgeAccounts deletes the accounts whose grace period is over, with
+// their export archives, and confirms the deletion to their owners.
+func (app *Application) purgeAccounts(ctx context.Context) error {
+	users, archives, err := app.store.User().PurgeDeactivated(ctx, time.Now().Add(-app.config.deletionGrace))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range archives {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("export archive %s: %v\n", path, err.Error())
+		}
+	}
+
+	for _, user := range users {
+		req := mailer.EmailRequest{
+			To:      user.Email,
+			Subject: "Your account has been deleted",
+			Body: "Hi " + user.Name + ",\n\nYour account and everything you shared have now " +
+				"been permanently deleted, as you requested.",
+		}
+		if err := app.mailer.SendEmail(req); err != nil {
+			log.Printf("encountered error sending mail: %v\n", err.Error())
+		}
+	}
+	return nil
+}
+
+// fanOutBatch is how many queued activities are fanned out per query.
+const fanOutBatch = 500
+
+// fanOut copies the queued activities to the timelines of followers until
+// the queue is drained.
+func (app *Application) fanOut(ctx context.Context) error {
+	for {
+		n, err := app.store.Timeline().FanOut(ctx, fanOutBatch)
+		if err != nil {
+			return err
+		}
+		if n < fanOutBatch {
+			return nil
+		}
+	}
+}
+
+// refreshRanking caches the scores of the ranked feeds with the configured
+// weights.
+func (app *Application) refreshRanking(ctx context.Context) error {
+	return app.store.User().RefreshRanking(ctx, app.config.ranking)
+}
+
+// publishBatch is how many scheduled posts are published per transaction.
+const publishBatch = 100
+
+// publishScheduled publishes the scheduled posts that are due, along with
+// their mentions, and fans them out.
+func (app *Application) publishScheduled(ctx context.Context) error {
+	for {
+		posts, err := app.store.Post().PublishDue(ctx, publishBatch)
+		if err != nil {
+			return err
+		}
+		linked := false
+		for _, post := range posts {
+			linked = linked || hasLinks(&post)
+		}
+		if len(posts) > 0 {
+			app.workers.Submit("timeline fan-out", app.fanOut)
+		}
+		if linked {
+			app.workers.Submit("link previews", app.unfurlLinks)
+		}
+		if len(posts) < publishBatch {
+			return nil
+		}
+	}
+}
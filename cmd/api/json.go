@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 
 	"github.com/go-playground/validator/v10"
@@ -27,5 +28,10 @@ func WriteJSON(w http.ResponseWriter, status int, data any) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 
+	return EncodeJSON(w, data)
+}
+
+// EncodeJSON writes data to w the way every response is encoded.
+func EncodeJSON(w io.Writer, data any) error {
 	return json.NewEncoder(w).Encode(data)
 }
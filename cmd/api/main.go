@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/Alter-Sitanshu/learning_Go/internal/auth"
+	"github.com/Alter-Sitanshu/learning_Go/internal/cache"
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
 	"github.com/Alter-Sitanshu/learning_Go/internal/database"
 	"github.com/Alter-Sitanshu/learning_Go/internal/env"
 	"github.com/Alter-Sitanshu/learning_Go/internal/mailer"
+	"github.com/Alter-Sitanshu/learning_Go/internal/scheduler"
+	"github.com/Alter-Sitanshu/learning_Go/internal/storage"
+	"github.com/Alter-Sitanshu/learning_Go/internal/stream"
+	"github.com/Alter-Sitanshu/learning_Go/internal/unfurl"
+	"github.com/Alter-Sitanshu/learning_Go/internal/worker"
 	"github.com/joho/godotenv"
 )
 
@@ -42,6 +51,35 @@ func main() {
 				exp:    time.Hour * 24 * 3,
 			},
 		},
+		deletionGrace: time.Hour * 24 * time.Duration(env.GetInt("DELETION_GRACE_DAYS", 30)),
+		apiURL:        env.GetString("API_URL", "http://localhost:8080"),
+		wsOrigins:     strings.Split(env.GetString("WS_ORIGINS", "http://localhost:3000"), ","),
+		export: ExportConfig{
+			dir:    env.GetString("EXPORT_DIR", "./exports"),
+			expiry: time.Hour * 24 * 7,
+		},
+		ranking: database.RankWeights{
+			Comments:  env.GetFloat("RANK_COMMENTS", database.DefaultRankWeights.Comments),
+			Reactions: env.GetFloat("RANK_REACTIONS", database.DefaultRankWeights.Reactions),
+			Reposts:   env.GetFloat("RANK_REPOSTS", database.DefaultRankWeights.Reposts),
+			Affinity:  env.GetFloat("RANK_AFFINITY", database.DefaultRankWeights.Affinity),
+			HalfLife: time.Hour * time.Duration(env.GetInt("RANK_HALF_LIFE_HOURS",
+				int(database.DefaultRankWeights.HalfLife.Hours()))),
+		},
+		media: MediaConfig{
+			store: env.GetString("MEDIA_STORE", "local"),
+			dir:   env.GetString("MEDIA_DIR", "./media"),
+			s3: storage.S3Config{
+				Endpoint:  env.GetString("S3_ENDPOINT", "http://localhost:9000"),
+				Region:    env.GetString("S3_REGION", "us-east-1"),
+				Bucket:    env.GetString("S3_BUCKET", "media"),
+				AccessKey: env.GetString("S3_ACCESS_KEY", ""),
+				SecretKey: env.GetString("S3_SECRET_KEY", ""),
+			},
+		},
+	}
+	if err := cfg.ranking.Validate(); err != nil {
+		log.Fatal(err.Error())
 	}
 
 	// Database initialisation
@@ -64,13 +102,65 @@ func main() {
 		Hostname,
 	)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Events stored by any instance are relayed to the users connected here
+	hub := stream.NewHub()
+	go func() {
+		if err := hub.Listen(ctx, cfg.db.addr, psql.Stream()); err != nil {
+			log.Printf("stream listener stopped: %v\n", err.Error())
+		}
+	}()
+
+	// Work left over by handlers once they responded
+	workers := worker.NewPool(4, 256)
+	go workers.Run(ctx)
+	// Resizing images is heavy, few run at once
+	mediaWorkers := worker.NewPool(2, 64)
+	go mediaWorkers.Run(ctx)
+
+	var blobs storage.BlobStore = storage.NewLocal(cfg.media.dir)
+	if cfg.media.store == "s3" {
+		blobs = storage.NewS3(cfg.media.s3)
+	}
+
 	app := &Application{
 		config:        cfg,
 		store:         psql,
 		mailer:        mailer,
 		authenticator: jwt,
+		hub:           hub,
+		cursors:       cursor.NewCodec(cfg.auth.token.secret),
+		workers:       workers,
+		mediaWorkers:  mediaWorkers,
+		explore:       cache.New[[]database.Feed](exploreTTL, 1000),
+		syndication:   cache.New[*syndicated](syndicationTTL, 1000),
+		blobs:         blobs,
+		unfurler:      unfurl.NewFetcher(unfurl.DefaultConfig),
 	}
 
+	// Background jobs
+	jobs := scheduler.New()
+	jobs.Add("follow suggestions", time.Hour, singleton(psql.User().RefreshSuggestions))
+	jobs.Add("account purge", time.Hour, singleton(app.purgeAccounts))
+	jobs.Add("data exports", time.Minute, app.buildExports)
+	jobs.Add("expired exports", time.Hour, app.expireExports)
+	// Catches activities left in the queue when a submitted fan-out was
+	// dropped or failed
+	jobs.Add("timeline fan-out", time.Minute, app.fanOut)
+	jobs.Add("feed ranking", time.Minute*15, singleton(app.refreshRanking))
+	jobs.Add("trending tags", time.Minute*10, singleton(psql.Tag().RefreshTrending))
+	jobs.Add("scheduled posts", time.Second*30, app.publishScheduled)
+	jobs.Add("orphaned media", time.Hour, app.purgeMedia)
+	// Catches images left pending when a submitted run was dropped or
+	// stalled
+	jobs.Add("media variants", time.Minute, app.processMedia)
+	// Catches links left waiting when a submitted run was dropped
+	jobs.Add("link previews", time.Minute, app.unfurlLinks)
+	jobs.Add("unused link previews", time.Hour, psql.LinkPreview().Prune)
+	go jobs.Run(ctx)
+
 	// Server Mux and Routing
 	HandlerMux := app.mount()
 
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+	"github.com/Alter-Sitanshu/learning_Go/internal/media"
+	"github.com/Alter-Sitanshu/learning_Go/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// mediaGrace is how long an upload may wait to be attached to a post
+// before it is removed.
+const mediaGrace = time.Hour * 24
+
+// UploadMediaHandler stores the image or video sent in the file field of
+// a multipart form. The upload is attached to a post by listing its ID in
+// the media of the post.
+func (app *Application) UploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	res := Response{}
+	// the multipart envelope adds little to the largest upload
+	r.Body = http.MaxBytesReader(w, r.Body, media.MaxVideoSize+1<<20)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		res.Message = "expected a multipart/form-data upload"
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	var data []byte
+	var mime string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			res.Message = "missing file field"
+			jsonResponse(w, http.StatusBadRequest, res)
+			return
+		}
+		if err == nil && part.FormName() != "file" {
+			continue
+		}
+		if err == nil {
+			data, mime, err = media.Read(part)
+		}
+		if err == nil {
+			data, err = media.Strip(mime, data)
+		}
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		break
+	}
+
+	ctx := r.Context()
+	m := &database.Media{
+		UserID: user.ID,
+		Key:    fmt.Sprintf("media/%d/%s%s", user.ID, uuid.NewString(), media.Ext(mime)),
+		Kind:   media.Kind(mime),
+		MIME:   mime,
+		Size:   int64(len(data)),
+		Status: database.MediaReady,
+	}
+	if m.Kind == media.Image {
+		m.Status = database.MediaPending
+	}
+	if err := app.blobs.Put(ctx, m.Key, bytes.NewReader(data), m.Size, mime); err != nil {
+		log.Printf("Error while storing media: %v\n", err.Error())
+		res.Message = "Server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+	if err := app.store.Media().Create(ctx, m); err != nil {
+		log.Printf("DB error: %v\n", err.Error())
+		if err := app.blobs.Delete(ctx, m.Key); err != nil {
+			log.Printf("Error while removing media: %v\n", err.Error())
+		}
+		res.Message = "Server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+	if m.Status == database.MediaPending {
+		app.mediaWorkers.Submit("media variants", app.processMedia)
+	}
+
+	app.setMediaURLs(user.ID, []database.Media{*m})
+	jsonResponse(w, http.StatusCreated, m)
+}
+
+// servesOriginal tells whether viewerID, 0 when not signed in, gets the
+// original of m: the uploader does, others only get the originals of
+// videos.
+func servesOriginal(m *database.Media, viewerID int64) bool {
+	return m.Kind != media.Image || viewerID != 0 && m.UserID == viewerID
+}
+
+// setMediaURLs fills in where the media and their variants are served to
+// viewerID.
+func (app *Application) setMediaURLs(viewerID int64, attached []database.Media) {
+	for i := range attached {
+		m := &attached[i]
+		base := app.config.apiURL + "/v1/media/" + strconv.FormatInt(m.ID, 10)
+		if servesOriginal(m, viewerID) {
+			m.URL = base
+		}
+		for j := range m.Variants {
+			m.Variants[j].URL = base + "/" + m.Variants[j].Name
+		}
+	}
+}
+
+// setFeedMediaURLs fills in where the media of the posts of feed are
+// served to viewerID.
+func (app *Application) setFeedMediaURLs(viewerID int64, feed []database.Feed) {
+	for i := range feed {
+		app.setMediaURLs(viewerID, feed[i].Post.Media)
+	}
+}
+
+// writeUploadError maps the errors of reading an upload to responses.
+func writeUploadError(w http.ResponseWriter, err error) {
+	res := Response{Message: err.Error()}
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, media.ErrUnsupported):
+		jsonResponse(w, http.StatusUnsupportedMediaType, res)
+	case errors.Is(err, media.ErrTooLarge), errors.As(err, &maxErr):
+		res.Message = media.ErrTooLarge.Error()
+		jsonResponse(w, http.StatusRequestEntityTooLarge, res)
+	case errors.Is(err, media.ErrMalformed):
+		jsonResponse(w, http.StatusBadRequest, res)
+	default:
+		res.Message = "could not read the upload"
+		jsonResponse(w, http.StatusBadRequest, res)
+	}
+}
+
+// GetMediaHandler serves the original of a medium to its uploader, and
+// of a video to those who can see the post it is attached to. Others get
+// the variants of images.
+func (app *Application) GetMediaHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID := viewerOf(r)
+	res := Response{}
+	id, err := strconv.ParseInt(chi.URLParam(r, "mediaID"), 10, 64)
+	if err != nil {
+		res.Message = "id should only contain integers."
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	m, err := app.store.Media().GetByID(r.Context(), id, viewerID)
+	if err != nil {
+		writeMediaError(w, err)
+		return
+	}
+	if !servesOriginal(m, viewerID) {
+		res.Message = "only the uploader gets the original, use a variant"
+		jsonResponse(w, http.StatusForbidden, res)
+		return
+	}
+
+	app.serveBlob(w, r, m.Key, m.MIME, m.Size, m.CreatedAt)
+}
+
+// GetMediaVariantHandler serves a resized copy of an image to those who
+// can see it.
+func (app *Application) GetMediaVariantHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID := viewerOf(r)
+	id, err := strconv.ParseInt(chi.URLParam(r, "mediaID"), 10, 64)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, Response{Message: "id should only contain integers."})
+		return
+	}
+
+	v, err := app.store.Media().GetVariant(r.Context(), id, chi.URLParam(r, "variant"), viewerID)
+	if err != nil {
+		writeMediaError(w, err)
+		return
+	}
+
+	// variants are only made once, they never change either
+	app.serveBlob(w, r, v.Key, v.MIME, v.Size, time.Time{})
+}
+
+func writeMediaError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrNotFound) {
+		jsonResponse(w, http.StatusNotFound, Response{Message: "Media not found"})
+		return
+	}
+	log.Printf("DB error: %v\n", err.Error())
+	jsonResponse(w, http.StatusInternalServerError, Response{Message: "Server error"})
+}
+
+// serveBlob writes the blob stored under key, ranges are served when the
+// store can seek so that players can seek through videos.
+func (app *Application) serveBlob(w http.ResponseWriter, r *http.Request,
+	key, mime string, size int64, modified time.Time) {
+	blob, err := app.blobs.Get(r.Context(), key)
+	if err != nil {
+		log.Printf("blob %s: %v\n", key, err.Error())
+		if errors.Is(err, storage.ErrNotFound) {
+			jsonResponse(w, http.StatusNotFound, Response{Message: "Media not found"})
+			return
+		}
+		jsonResponse(w, http.StatusInternalServerError, Response{Message: "Server error"})
+		return
+	}
+	defer blob.Close()
+
+	// the content under a key never changes
+	w.Header().Set("Content-Type", mime)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if rs, ok := blob.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", modified, rs)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	io.Copy(w, blob)
+}
+
+// processMedia resizes the pending images into their variants, until none
+// is left.
+func (app *Application) processMedia(ctx context.Context) error {
+	for {
+		m, err := app.store.Media().Claim(ctx)
+		if errors.Is(err, database.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := app.makeVariants(ctx, m); err != nil {
+			log.Printf("media %d: %v\n", m.ID, err.Error())
+			// images that can not be decoded never will be, others are
+			// retried once the claim times out
+			if errors.Is(err, media.ErrMalformed) || errors.Is(err, media.ErrTooLarge) {
+				if err := app.store.Media().Fail(ctx, m.ID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// makeVariants resizes the image m and stores its variants next to it.
+func (app *Application) makeVariants(ctx context.Context, m *database.Media) error {
+	blob, err := app.blobs.Get(ctx, m.Key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		return err
+	}
+
+	processed, err := media.Process(data)
+	if err != nil {
+		return err
+	}
+	m.Width = processed.Width
+	m.Height = processed.Height
+	m.Blurhash = processed.Blurhash
+	m.Variants = m.Variants[:0]
+	base := strings.TrimSuffix(m.Key, path.Ext(m.Key))
+	for _, v := range processed.Variants {
+		variant := database.MediaVariant{
+			Name:   v.Name,
+			Key:    base + "/" + v.Name + ".jpg",
+			MIME:   media.VariantMIME,
+			Width:  v.Width,
+			Height: v.Height,
+			Size:   int64(len(v.Data)),
+		}
+		if err := app.blobs.Put(ctx, variant.Key, bytes.NewReader(v.Data), variant.Size, variant.MIME); err != nil {
+			return err
+		}
+		m.Variants = append(m.Variants, variant)
+	}
+	return app.store.Media().Complete(ctx, m)
+}
+
+// purgeMedia removes the uploads that were not attached to a post in time
+// or whose post was deleted.
+func (app *Application) purgeMedia(ctx context.Context) error {
+	keys, err := app.store.Media().PurgeOrphans(ctx, time.Now().Add(-mediaGrace))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := app.blobs.Delete(ctx, key); err != nil {
+			log.Printf("blob %s: %v\n", key, err.Error())
+		}
+	}
+	return nil
+}
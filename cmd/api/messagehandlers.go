@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+type ConvKey string
+
+const convctx ConvKey = "conversation"
+
+type MessagePayload struct {
+	Content string `json:"content" validate:"required,max=2000"`
+}
+
+func getConversationFromCtx(r *http.Request) *database.Conversation {
+	conv, _ := r.Context().Value(convctx).(*database.Conversation)
+	return conv
+}
+
+// writeMessageError maps the errors of the conversation store to responses.
+func writeMessageError(w http.ResponseWriter, err error) {
+	res := Response{
+		Message: err.Error(),
+	}
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		res.Message = "Conversation not found"
+		jsonResponse(w, http.StatusNotFound, res)
+	case errors.Is(err, database.ErrDMNotAllowed):
+		jsonResponse(w, http.StatusForbidden, res)
+	case errors.Is(err, database.ErrTooManyMembers),
+		errors.Is(err, database.ErrNoSuchRecipients):
+		jsonResponse(w, http.StatusBadRequest, res)
+	default:
+		log.Printf("DB error: %v\n", err.Error())
+		res.Message = "server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+	}
+}
+
+// ConversationMiddleware fetches the conversation of the URL, only its
+// members can see it.
+func (app *Application) ConversationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := getUserFromCtx(r)
+		id, err := strconv.ParseInt(chi.URLParam(r, "conversationID"), 10, 64)
+		if err != nil {
+			jsonResponse(w, http.StatusBadRequest, Response{Message: "id should only contain integers."})
+			return
+		}
+
+		ctx := r.Context()
+		conv, err := app.store.Conversation().GetConversation(ctx, id, user.ID)
+		if err != nil {
+			writeMessageError(w, err)
+			return
+		}
+		ctx = context.WithValue(ctx, convctx, conv)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (app *Application) CreateConversationHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	type conversationPayload struct {
+		MemberIDs []int64 `json:"member_ids" validate:"required,min=1"`
+	}
+	var payload conversationPayload
+
+	if err := ReadJSON(w, r, &payload); err != nil {
+		jsonResponse(w, http.StatusBadRequest, Response{Message: "Incorrect data format"})
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		jsonResponse(w, http.StatusBadRequest, Response{Message: err.Error()})
+		return
+	}
+
+	conv, err := app.store.Conversation().CreateConversation(r.Context(), user.ID, payload.MemberIDs)
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, conv)
+}
+
+func (app *Application) GetConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	convs, err := app.store.Conversation().GetConversations(r.Context(), user.ID)
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, convs)
+}
+
+func (app *Application) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	conv := getConversationFromCtx(r)
+
+	page, err := app.parsePage(r, "messages", 50, 100)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, Response{Message: "Bad request: " + err.Error()})
+		return
+	}
+
+	messages, err := app.store.Conversation().GetMessages(r.Context(), conv.ID, user.ID, page.After.ID, page.Limit)
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	var next string
+	if n := len(messages); n > 0 {
+		next = page.nextCursor(n, cursor.Key{ID: messages[n-1].ID})
+	}
+	paginatedResponse(w, http.StatusOK, messages, next, "")
+}
+
+func (app *Application) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	conv := getConversationFromCtx(r)
+	var payload MessagePayload
+
+	if err := ReadJSON(w, r, &payload); err != nil {
+		jsonResponse(w, http.StatusBadRequest, Response{Message: "Incorrect data format"})
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		jsonResponse(w, http.StatusBadRequest, Response{Message: err.Error()})
+		return
+	}
+
+	msg := &database.Message{
+		ConversationID: conv.ID,
+		SenderID:       user.ID,
+		Content:        payload.Content,
+	}
+	if err := app.store.Conversation().SendMessage(r.Context(), msg); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, msg)
+}
+
+func (app *Application) MarkConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	conv := getConversationFromCtx(r)
+	type readPayload struct {
+		MessageID int64 `json:"message_id" validate:"required,gt=0"`
+	}
+	var payload readPayload
+
+	if err := ReadJSON(w, r, &payload); err != nil {
+		jsonResponse(w, http.StatusBadRequest, Response{Message: "Incorrect data format"})
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		jsonResponse(w, http.StatusBadRequest, Response{Message: err.Error()})
+		return
+	}
+
+	receipt := &database.ReadReceipt{
+		ConversationID: conv.ID,
+		UserID:         user.ID,
+		MessageID:      payload.MessageID,
+	}
+	if err := app.store.Conversation().MarkRead(r.Context(), receipt); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, receipt)
+}
+
+func (app *Application) DMSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	type settingsPayload struct {
+		MutualsOnly bool `json:"mutuals_only"`
+	}
+	var payload settingsPayload
+
+	if err := ReadJSON(w, r, &payload); err != nil {
+		jsonResponse(w, http.StatusBadRequest, Response{Message: "Incorrect data format"})
+		return
+	}
+
+	err := app.store.Conversation().SetMutualsOnly(r.Context(), user.ID, payload.MutualsOnly)
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, Response{Message: "Settings updated"})
+}
@@ -56,6 +56,20 @@ func (app *Application) AuthorizationMiddleware(next http.Handler) http.Handler
 	})
 }
 
+// OptionalAuthMiddleware authorises the requests that carry a token like
+// AuthorizationMiddleware does, and lets anonymous requests through with no
+// user in their context.
+func (app *Application) OptionalAuthMiddleware(next http.Handler) http.Handler {
+	authorised := app.AuthorizationMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authorised.ServeHTTP(w, r)
+	})
+}
+
 func (app *Application) checkRoleMiddleware(RequiredRole string, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := getUserFromCtx(r)
@@ -85,6 +99,29 @@ func (app *Application) checkRoleMiddleware(RequiredRole string, next http.Handl
 	})
 }
 
+// requireRoleMiddleware lets through the users whose role is at least
+// RequiredRole.
+func (app *Application) requireRoleMiddleware(RequiredRole string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := getUserFromCtx(r)
+		var res Response
+
+		allowed, err := app.checkRole(r.Context(), RequiredRole, user.Role)
+		if err != nil {
+			res.Message = "server error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+			return
+		}
+		if !allowed {
+			res.Message = "restricted"
+			jsonResponse(w, http.StatusForbidden, res)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *Application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
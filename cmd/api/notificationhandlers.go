@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func (app *Application) GetNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	res := Response{}
+
+	page, err := app.parsePage(r, "notifications", 20, 50)
+	if err != nil {
+		log.Printf("Bad Request: %v\n", err.Error())
+		res.Message = "Bad request: " + err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	nq := &database.NotificationQuery{
+		Unread: r.URL.Query().Get("unread") == "true",
+		Before: page.After.ID,
+		Limit:  page.Limit,
+	}
+
+	notifications, err := app.store.Notification().GetNotifications(r.Context(), user.ID, nq)
+	if err != nil {
+		log.Printf("Server Error: %v\n", err.Error())
+		res.Message = "server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	var next string
+	if n := len(notifications); n > 0 {
+		next = page.nextCursor(n, cursor.Key{ID: notifications[n-1].ID})
+	}
+	paginatedResponse(w, http.StatusOK, notifications, next, "")
+}
+
+func (app *Application) MarkNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	res := Response{
+		Message: "Notification read",
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "notificationID"), 10, 64)
+	if err != nil {
+		res.Message = "id should only contain integers."
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	err = app.store.Notification().MarkRead(r.Context(), user.ID, id)
+	if err != nil {
+		log.Printf("DB error: %v\n", err.Error())
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			res.Message = "Notification not found"
+			jsonResponse(w, http.StatusNotFound, res)
+		default:
+			res.Message = "server error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+		}
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, res)
+}
+
+func (app *Application) MarkAllNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	res := Response{
+		Message: "All notifications read",
+	}
+
+	if err := app.store.Notification().MarkAllRead(r.Context(), user.ID); err != nil {
+		log.Printf("DB error: %v\n", err.Error())
+		res.Message = "server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, res)
+}
+
+func (app *Application) GetNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	prefs, err := app.store.Notification().GetPreferences(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("DB error: %v\n", err.Error())
+		jsonResponse(w, http.StatusInternalServerError, Response{Message: "server error"})
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, prefs)
+}
+
+func (app *Application) SetNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	res := Response{
+		Message: "Preferences updated",
+	}
+	var payload []database.NotificationPreference
+
+	if err := ReadJSON(w, r, &payload); err != nil {
+		res.Message = "Incorrect data format"
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	for _, pref := range payload {
+		if err := Validate.Struct(pref); err != nil {
+			res.Message = "Validation failed: unknown notification type"
+			jsonResponse(w, http.StatusBadRequest, res)
+			return
+		}
+	}
+
+	if err := app.store.Notification().SetPreferences(r.Context(), user.ID, payload); err != nil {
+		log.Printf("DB error: %v\n", err.Error())
+		res.Message = "server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, res)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
+)
+
+// Page is the position and size of a keyset paginated listing request.
+type Page struct {
+	After cursor.Key
+	Limit int
+	// Set says whether the request carried a cursor
+	Set bool
+
+	list  string
+	codec *cursor.Codec
+}
+
+// parsePage reads the cursor and limit query parameters of the listing
+// list, cursors of other listings are rejected.
+func (app *Application) parsePage(r *http.Request, list string, defaultLimit, maxLimit int) (Page, error) {
+	query := r.URL.Query()
+	page := Page{
+		Limit: defaultLimit,
+		list:  list,
+		codec: app.cursors,
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxLimit {
+			return page, errors.New("limit should be between 1 and " + strconv.Itoa(maxLimit))
+		}
+		page.Limit = l
+	}
+	if c := query.Get("cursor"); c != "" {
+		key, err := app.cursors.Decode(c, list, "")
+		if err != nil {
+			return page, err
+		}
+		page.After = key
+		page.Set = true
+	}
+	return page, nil
+}
+
+// nextCursor returns the cursor of the page following one that ended
+// with key, or nothing when the page was not full and so was the last.
+func (p Page) nextCursor(n int, key cursor.Key) string {
+	if n < p.Limit {
+		return ""
+	}
+	key.List = p.list
+	return p.codec.Encode(key)
+}
+
+// prevCursor returns the cursor of the page preceding one that started
+// with key.
+func (p Page) prevCursor(key cursor.Key) string {
+	key.List, key.Back = p.list, true
+	return p.codec.Encode(key)
+}
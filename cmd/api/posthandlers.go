@@ -3,12 +3,18 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
 	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+	"github.com/Alter-Sitanshu/learning_Go/internal/entities"
+	"github.com/Alter-Sitanshu/learning_Go/internal/markup"
+	"github.com/Alter-Sitanshu/learning_Go/internal/unfurl"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -20,12 +26,59 @@ type PostPayload struct {
 	Title   string   `json:"title" validate:"required,max=250"`
 	Content string   `json:"content" validate:"required,max=1024"`
 	Tags    []string `json:"tags"`
+	// Language is the text search configuration the post is stemmed with
+	Language string `json:"language" validate:"omitempty,oneof=simple english french german spanish italian portuguese dutch russian"`
+	// Format defaults to plain text
+	Format string `json:"format" validate:"omitempty,oneof=plain markdown"`
+	// Status defaults to published, scheduled posts need PublishAt
+	Status    string     `json:"status" validate:"omitempty,oneof=draft scheduled published"`
+	PublishAt *time.Time `json:"publish_at"`
+	// Media are the IDs of uploads of the user to attach, in order
+	Media []int64 `json:"media" validate:"max=4,unique"`
+}
+
+// Payload struct for updating drafts and scheduled posts
+type DraftMutate struct {
+	Title     *string    `json:"title" validate:"omitempty,max=250"`
+	Content   *string    `json:"content" validate:"omitempty,max=1024"`
+	Format    *string    `json:"format" validate:"omitempty,oneof=plain markdown"`
+	Status    *string    `json:"status" validate:"omitempty,oneof=draft scheduled published"`
+	PublishAt *time.Time `json:"publish_at"`
+}
+
+// renderContent renders the content of post, in its format, to sanitized
+// HTML.
+func renderContent(post *database.Post) error {
+	html, err := markup.Render(post.Format, post.Content)
+	post.ContentHTML = html
+	return err
+}
+
+// hasLinks tells whether post is published with links to be previewed.
+func hasLinks(post *database.Post) bool {
+	return post.Status == database.PostPublished && len(unfurl.ExtractURLs(post.Content)) > 0
+}
+
+// checkSchedule tells whether a post can be given status and publishAt:
+// only scheduled posts have a publication time, and it lies ahead.
+func checkSchedule(status string, publishAt *time.Time) error {
+	if status != database.PostScheduled {
+		if publishAt != nil {
+			return errors.New("publish_at is only set on scheduled posts")
+		}
+		return nil
+	}
+	if publishAt == nil || !publishAt.After(time.Now()) {
+		return errors.New("scheduled posts need a publish_at in the future")
+	}
+	return nil
 }
 
 // Payload struct for updating Posts
 type PostMutate struct {
 	Title   *string `json:"title" validate:"omitempty,max=100"`
 	Content *string `json:"content" validate:"omitempty,max=1000"`
+	Format  *string `json:"format" validate:"omitempty,oneof=plain markdown"`
 }
 
 type Response struct {
@@ -52,6 +105,18 @@ func jsonResponse(w http.ResponseWriter, status int, data any) error {
 	return nil
 }
 
+// paginatedResponse is jsonResponse for keyset paginated listings, next is
+// the cursor of the following page and is left out on the last page, prev
+// the cursor of the preceding page for listings that can be scrolled back.
+func paginatedResponse(w http.ResponseWriter, status int, data any, next, prev string) error {
+	type envelope struct {
+		Data       any    `json:"data"`
+		NextCursor string `json:"next_cursor,omitempty"`
+		PrevCursor string `json:"prev_cursor,omitempty"`
+	}
+	return WriteJSON(w, status, &envelope{Data: data, NextCursor: next, PrevCursor: prev})
+}
+
 func (app *Application) CreatPostHandler(w http.ResponseWriter, r *http.Request) {
 	var payload PostPayload
 	var res Response
@@ -71,21 +136,64 @@ func (app *Application) CreatPostHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	user := getUserFromCtx(r)
+	ents := entities.Parse(payload.Content)
 
 	post := database.Post{
-		Title:   payload.Title,
-		Content: payload.Content,
-		UserID:  user.ID,
-		Tags:    payload.Tags,
+		Title:     payload.Title,
+		Content:   payload.Content,
+		UserID:    user.ID,
+		Tags:      mergeTags(payload.Tags, ents.Tags()),
+		Language:  payload.Language,
+		Format:    payload.Format,
+		Status:    payload.Status,
+		PublishAt: payload.PublishAt,
+	}
+	if post.Format == "" {
+		post.Format = markup.Plain
+	}
+	if err := renderContent(&post); err != nil {
+		log.Printf("Error while rendering post: %v\n", err.Error())
+		res.Message = "Server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+	for _, id := range payload.Media {
+		post.Media = append(post.Media, database.Media{ID: id})
+	}
+	if post.Language == "" {
+		post.Language = database.DefaultLanguage
+	}
+	if post.Status == "" {
+		post.Status = database.PostPublished
+	}
+	if err := checkSchedule(post.Status, post.PublishAt); err != nil {
+		res.Message = err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
 	}
 	ctx := r.Context()
 	err := app.store.Post().Create(ctx, &post)
+	if errors.Is(err, database.ErrMediaNotFound) {
+		res.Message = err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
 	if err != nil {
 		log.Printf("DB Error: %s", err.Error())
 		res.Message = fmt.Sprintf("Error creating post into database: %v", err)
 		jsonResponse(w, http.StatusInternalServerError, res)
 		return
 	}
+	if hasLinks(&post) {
+		app.workers.Submit("link previews", app.unfurlLinks)
+	}
+	// Mentions of drafts and scheduled posts are resolved on publication
+	if post.Status == database.PostPublished {
+		app.workers.Submit("timeline fan-out", app.fanOut)
+	} else {
+		post.Entities = &ents
+	}
+	app.setMediaURLs(user.ID, post.Media)
 	if err := jsonResponse(w, http.StatusCreated, post); err != nil {
 		log.Printf("Error while encoding post: %s", err.Error())
 		res.Message = "Error encoding post"
@@ -108,6 +216,7 @@ func (app *Application) GetPostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	post.Comments = comments
+	app.setMediaURLs(getUserFromCtx(r).ID, post.Media)
 	jsonResponse(w, http.StatusOK, post)
 }
 
@@ -150,9 +259,18 @@ func (app *Application) UpdatePostHandler(w http.ResponseWriter, r *http.Request
 		post.Title = *updatepayload.Title
 	}
 	if updatepayload.Content != nil {
+		post.Tags = retag(post.Tags, post.Content, *updatepayload.Content)
 		post.Content = *updatepayload.Content
 	}
-
+	if updatepayload.Format != nil {
+		post.Format = *updatepayload.Format
+	}
+	if err := renderContent(post); err != nil {
+		log.Printf("Error while rendering post: %v\n", err.Error())
+		res.Message = "Server Error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
 	err := app.store.Post().UpdatePost(r.Context(), post)
 	if err != nil {
 		log.Printf("DB error: %v", err.Error())
@@ -160,13 +278,123 @@ func (app *Application) UpdatePostHandler(w http.ResponseWriter, r *http.Request
 		jsonResponse(w, http.StatusInternalServerError, res)
 		return
 	}
+	if hasLinks(post) {
+		app.workers.Submit("link previews", app.unfurlLinks)
+	}
 
 	jsonResponse(w, http.StatusOK, res)
 }
 
+func (app *Application) GetDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	res := Response{}
+	page, err := app.parsePage(r, "drafts", 20, 50)
+	if err != nil {
+		res.Message = "Bad request: " + err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	drafts, err := app.store.Post().GetDrafts(r.Context(), user.ID, page.After, page.Limit)
+	if err != nil {
+		log.Printf("DB error: %v\n", err.Error())
+		res.Message = "Server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+	for i := range drafts {
+		app.setMediaURLs(user.ID, drafts[i].Media)
+	}
+
+	var next string
+	if n := len(drafts); n > 0 {
+		next = page.nextCursor(n, cursor.Key{ID: drafts[n-1].ID})
+	}
+	paginatedResponse(w, http.StatusOK, drafts, next, "")
+}
+
+// UpdateDraftHandler edits a draft or scheduled post of the current user,
+// setting its status to published publishes it.
+func (app *Application) UpdateDraftHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	post := getPostbyctx(r)
+	res := Response{}
+	if post.Status == database.PostPublished {
+		res.Message = "post is already published"
+		jsonResponse(w, http.StatusConflict, res)
+		return
+	}
+
+	var payload DraftMutate
+	if err := ReadJSON(w, r, &payload); err != nil {
+		res.Message = "Incorrect data format"
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		res.Message = err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	if payload.Title != nil {
+		post.Title = *payload.Title
+	}
+	if payload.Content != nil {
+		post.Content = *payload.Content
+	}
+	if payload.Format != nil {
+		post.Format = *payload.Format
+	}
+	if payload.Status != nil {
+		post.Status = *payload.Status
+		if post.Status != database.PostScheduled {
+			post.PublishAt = nil
+		}
+	}
+	if payload.PublishAt != nil {
+		post.PublishAt = payload.PublishAt
+	}
+	if err := checkSchedule(post.Status, post.PublishAt); err != nil {
+		res.Message = err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	ents := entities.Parse(post.Content)
+	post.Tags = mergeTags(post.Tags, ents.Tags())
+	if err := renderContent(post); err != nil {
+		log.Printf("Error while rendering post: %v\n", err.Error())
+		res.Message = "Server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.store.Post().UpdateDraft(ctx, post); err != nil {
+		switch {
+		case errors.Is(err, database.ErrDraftConflict):
+			res.Message = err.Error()
+			jsonResponse(w, http.StatusConflict, res)
+		default:
+			log.Printf("DB error: %v\n", err.Error())
+			res.Message = "Server error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+		}
+		return
+	}
+	if hasLinks(post) {
+		app.workers.Submit("link previews", app.unfurlLinks)
+	}
+	if post.Status == database.PostPublished {
+		app.workers.Submit("timeline fan-out", app.fanOut)
+	}
+
+	app.setMediaURLs(user.ID, post.Media)
+	jsonResponse(w, http.StatusOK, post)
+}
+
 func (app *Application) PostMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user := getUserFromCtx(r)
 		postID := chi.URLParam(r, "id")
 		id, err := strconv.ParseInt(postID, 10, 64)
 		res := Response{
@@ -179,21 +407,50 @@ func (app *Application) PostMiddleware(next http.Handler) http.Handler {
 			return
 		}
 		ctx := r.Context()
-		post, err := app.store.Post().GetPostByID(ctx, id)
+		user := getUserFromCtx(r)
+		post, err := app.store.Post().GetPostByID(ctx, id, user.ID)
 		if err != nil {
 			log.Printf("DB Error occured: %s", err.Error())
 			res.Message = "Not Found"
 			jsonResponse(w, http.StatusNotFound, res)
 			return
 		}
+		ctx = context.WithValue(ctx, postctx, post)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// PostOwnerMiddleware rejects requests on a post fetched by PostMiddleware
+// unless the requesting user wrote it.
+func (app *Application) PostOwnerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := getUserFromCtx(r)
+		post := getPostbyctx(r)
 		if post.UserID != user.ID {
 			log.Printf("Restricted: Request for id: %d, but userid: %d", post.UserID, user.ID)
-			res.Message = "Restricted: Request Rejected"
+			res := Response{
+				Message: "Restricted: Request Rejected",
+			}
 			jsonResponse(w, http.StatusNotAcceptable, res)
 			return
 		}
-		ctx = context.WithValue(ctx, postctx, post)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PublishedMiddleware rejects requests on a post fetched by PostMiddleware
+// that is not published yet, drafts can not be shared or reacted to.
+func (app *Application) PublishedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		post := getPostbyctx(r)
+		if post.Status != database.PostPublished {
+			res := Response{
+				Message: "post is not published",
+			}
+			jsonResponse(w, http.StatusConflict, res)
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -224,13 +481,253 @@ func (app *Application) CreateCommentHandler(w http.ResponseWriter, r *http.Requ
 	comment.Postid = post.ID
 	comment.Userid = userid
 
-	err := app.store.Comment().CreateComment(r.Context(), &comment)
+	ctx := r.Context()
+	err := app.store.Comment().CreateComment(ctx, &comment)
+	if err != nil {
+		log.Printf("DB error: %v", err.Error())
+		switch {
+		case errors.Is(err, database.ErrBlocked):
+			res.Message = err.Error()
+			jsonResponse(w, http.StatusForbidden, res)
+		default:
+			res.Message = "Server Error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+		}
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, comment)
+}
+
+func (app *Application) RepostHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostbyctx(r)
+	user := getUserFromCtx(r)
+	res := Response{
+		Message: "Post reposted",
+	}
+	type repostPayload struct {
+		Quote string `json:"quote" validate:"max=300"`
+	}
+	var payload repostPayload
+
+	// The quote is optional, so an empty body is a plain repost
+	if r.ContentLength != 0 {
+		if err := ReadJSON(w, r, &payload); err != nil {
+			res.Message = "Incorrect data format"
+			jsonResponse(w, http.StatusBadRequest, res)
+			return
+		}
+	}
+	if err := Validate.Struct(payload); err != nil {
+		res.Message = "Validation failed: quote may be too long"
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	repost := &database.Repost{
+		PostID: post.ID,
+		UserID: user.ID,
+		Quote:  payload.Quote,
+	}
+	err := app.store.Repost().Create(r.Context(), repost)
+	if err != nil {
+		log.Printf("DB error: %v", err.Error())
+		switch {
+		case errors.Is(err, database.ErrDupliRepost):
+			res.Message = err.Error()
+			jsonResponse(w, http.StatusConflict, res)
+		case errors.Is(err, database.ErrSelfRepost):
+			res.Message = err.Error()
+			jsonResponse(w, http.StatusBadRequest, res)
+		default:
+			res.Message = "Server Error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+		}
+		return
+	}
+	app.workers.Submit("timeline fan-out", app.fanOut)
+
+	jsonResponse(w, http.StatusCreated, repost)
+}
+
+func (app *Application) UndoRepostHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostbyctx(r)
+	user := getUserFromCtx(r)
+	res := Response{
+		Message: "Repost removed",
+	}
+
+	err := app.store.Repost().Delete(r.Context(), post.ID, user.ID)
 	if err != nil {
+		log.Printf("DB error: %v", err.Error())
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			res.Message = "Repost not found"
+			jsonResponse(w, http.StatusNotFound, res)
+		default:
+			res.Message = "Server Error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+		}
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, res)
+}
+
+func (app *Application) ReactHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostbyctx(r)
+	user := getUserFromCtx(r)
+	res := Response{}
+	type reactionPayload struct {
+		Kind string `json:"kind" validate:"required,oneof=like love laugh wow sad angry"`
+	}
+	var payload reactionPayload
+
+	if err := ReadJSON(w, r, &payload); err != nil {
+		res.Message = "Incorrect data format"
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		res.Message = "Validation failed: unknown reaction"
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	reaction := &database.Reaction{
+		PostID: post.ID,
+		UserID: user.ID,
+		Kind:   payload.Kind,
+	}
+	if err := app.store.Reaction().React(r.Context(), reaction); err != nil {
 		log.Printf("DB error: %v", err.Error())
 		res.Message = "Server Error"
 		jsonResponse(w, http.StatusInternalServerError, res)
 		return
 	}
 
+	jsonResponse(w, http.StatusOK, reaction)
+}
+
+func (app *Application) UnreactHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostbyctx(r)
+	user := getUserFromCtx(r)
+	res := Response{
+		Message: "Reaction removed",
+	}
+
+	err := app.store.Reaction().Unreact(r.Context(), post.ID, user.ID)
+	if err != nil {
+		log.Printf("DB error: %v", err.Error())
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			res.Message = "Reaction not found"
+			jsonResponse(w, http.StatusNotFound, res)
+		default:
+			res.Message = "Server Error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+		}
+		return
+	}
+
 	jsonResponse(w, http.StatusOK, res)
 }
+
+func (app *Application) SearchPostsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	sq := &database.PostSearchQuery{
+		Lang:  database.DefaultLanguage,
+		Limit: 20,
+	}
+	res := Response{}
+	err := sq.Parse(r)
+	if err != nil {
+		log.Printf("Bad Request: %v\n", err.Error())
+		res.Message = "Bad request: Error while parsing"
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	if err = Validate.Struct(sq); err != nil {
+		log.Printf("Bad Request: %v\n", err.Error())
+		res.Message = err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	posts, err := app.store.Post().SearchPosts(r.Context(), user.ID, sq)
+	if err != nil {
+		log.Printf("Server Error: %v\n", err.Error())
+		res.Message = "server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+	for i := range posts {
+		app.setMediaURLs(user.ID, posts[i].Media)
+	}
+
+	jsonResponse(w, http.StatusOK, posts)
+}
+
+// exploreTTL is how long an explore page is served from the cache to
+// anonymous users.
+const exploreTTL = time.Second * 30
+
+// ExploreHandler lists the recent public posts of every user. Pages are
+// the same for every anonymous user, they are cached and may be cached by
+// clients and proxies too.
+func (app *Application) ExploreHandler(w http.ResponseWriter, r *http.Request) {
+	// every response depends on who asks, errors included, or a proxy
+	// could serve a signed in user's page to anyone
+	w.Header().Set("Vary", "Authorization")
+	fq := &database.FilteringQuery{
+		Limit:   20,
+		Sort:    "desc",
+		Weights: app.config.ranking,
+	}
+	res := Response{}
+	err := fq.Parse(r)
+	if err != nil {
+		log.Printf("Bad Request: %v\n", err.Error())
+		res.Message = "Bad request: Error while parsing"
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	if err = Validate.Struct(fq); err != nil {
+		log.Printf("Bad Request: %v\n", err.Error())
+		res.Message = err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	var viewerID int64
+	user := getUserFromCtx(r)
+	if user != nil {
+		viewerID = user.ID
+	}
+	// the encoded query sorts its parameters, equal requests share a key
+	key := r.URL.Query().Encode()
+	if user != nil {
+		w.Header().Set("Cache-Control", "private")
+	} else {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(exploreTTL.Seconds())))
+		if feed, ok := app.explore.Get(key); ok {
+			jsonResponse(w, http.StatusOK, feed)
+			return
+		}
+	}
+
+	feed, err := app.store.Post().Explore(r.Context(), viewerID, fq)
+	if err != nil {
+		log.Printf("Server Error: %v\n", err.Error())
+		w.Header().Del("Cache-Control")
+		res.Message = "server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+	app.setFeedMediaURLs(viewerID, feed)
+	if user == nil {
+		app.explore.Set(key, feed)
+	}
+
+	jsonResponse(w, http.StatusOK, feed)
+}
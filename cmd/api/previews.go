@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+)
+
+// unfurlLinks fetches the previews of the links waiting for one, until
+// none is left.
+func (app *Application) unfurlLinks(ctx context.Context) error {
+	for {
+		preview, err := app.store.LinkPreview().Claim(ctx)
+		if errors.Is(err, database.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fetched, err := app.unfurler.Fetch(ctx, preview.URL)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("link preview %s: %v\n", preview.URL, err.Error())
+			if err := app.store.LinkPreview().Fail(ctx, preview.URL); err != nil {
+				return err
+			}
+			continue
+		}
+
+		preview.Title = fetched.Title
+		preview.Description = fetched.Description
+		preview.Image = fetched.Image
+		preview.SiteName = fetched.SiteName
+		if err := app.store.LinkPreview().Complete(ctx, preview); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+)
+
+const heartbeatInterval = 15 * time.Second
+
+// catchUpBatch is how many missed events are read from the store at once.
+const catchUpBatch = 500
+
+func writeEvent(w http.ResponseWriter, ev database.StreamEvent) error {
+	// Transient events are not stored and can not be resumed from
+	if ev.Seq == 0 {
+		_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, ev.Payload)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, ev.Payload)
+	return err
+}
+
+// StreamHandler pushes the events of the user as Server-Sent Events. The
+// route is mounted outside of the request timeout and lifts the server
+// write deadline, the connection is kept alive with heartbeats instead.
+func (app *Application) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	ctx := r.Context()
+	res := Response{}
+
+	var last int64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		var err error
+		last, err = strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			res.Message = "Last-Event-ID should be an integer"
+			jsonResponse(w, http.StatusBadRequest, res)
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("stream unsupported: %v\n", err.Error())
+		res.Message = "streaming unsupported"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	// Subscribe before catching up so that nothing stored in between is
+	// lost, events already sent are skipped by sequence number.
+	sub := app.hub.Subscribe(user.ID)
+	defer sub.Close()
+
+	var missed []database.StreamEvent
+	if last > 0 {
+		var err error
+		missed, err = app.store.Stream().Since(ctx, user.ID, last, catchUpBatch)
+		if err != nil {
+			log.Printf("DB error: %v\n", err.Error())
+			res.Message = "server error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+
+	// Missed events are replayed batch by batch until the store has no
+	// more, only then are the events of the subscription sent
+	for {
+		for _, ev := range missed {
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+			last = ev.Seq
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		if len(missed) < catchUpBatch {
+			break
+		}
+		var err error
+		missed, err = app.store.Stream().Since(ctx, user.ID, last, catchUpBatch)
+		if err != nil {
+			// the client reconnects from the last event it was sent
+			log.Printf("DB error: %v\n", err.Error())
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			// numbers are drawn in commit order, an event at or below
+			// last was already replayed from the store
+			if ev.Seq != 0 && ev.Seq <= last {
+				continue
+			}
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+			last = max(last, ev.Seq)
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
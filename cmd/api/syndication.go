@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+	"github.com/Alter-Sitanshu/learning_Go/internal/syndication"
+	"github.com/go-chi/chi/v5"
+)
+
+// syndicationTTL is how long a rendered RSS or Atom feed is served from the
+// cache, and may be cached by feed readers.
+const syndicationTTL = time.Minute * 5
+
+// syndicated is a rendered RSS or Atom feed.
+type syndicated struct {
+	body        []byte
+	contentType string
+	etag        string
+	modified    time.Time
+}
+
+var contentTypes = map[string]string{
+	"rss":  "application/rss+xml; charset=utf-8",
+	"atom": "application/atom+xml; charset=utf-8",
+}
+
+// UserSyndicationHandler serves the latest posts of a public account as an
+// RSS or Atom feed, no authentication is needed.
+func (app *Application) UserSyndicationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := parseUserID(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, Response{Message: "id should only contain integers."})
+		return
+	}
+	if doc, ok := app.syndication.Get(r.URL.Path); ok {
+		serveSyndicated(w, r, doc)
+		return
+	}
+
+	user, posts, err := app.store.Post().GetSyndicated(r.Context(), userID)
+	if err != nil {
+		app.syndicationError(w, err, "User not found")
+		return
+	}
+
+	name := user.DisplayName
+	if name == "" {
+		name = user.Name
+	}
+	app.publish(w, r, &syndication.Channel{
+		Title:       name + " (@" + user.Name + ")",
+		Description: "The latest posts of @" + user.Name,
+		Link:        app.config.apiURL + "/v1/users/" + strconv.FormatInt(user.ID, 10),
+	}, posts)
+}
+
+// TagSyndicationHandler serves the latest posts of public accounts tagged
+// with a tag as an RSS or Atom feed, no authentication is needed.
+func (app *Application) TagSyndicationHandler(w http.ResponseWriter, r *http.Request) {
+	slug, err := parseTag(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, Response{Message: "Bad request: " + err.Error()})
+		return
+	}
+	if doc, ok := app.syndication.Get(r.URL.Path); ok {
+		serveSyndicated(w, r, doc)
+		return
+	}
+
+	posts, err := app.store.Tag().GetSyndicated(r.Context(), slug)
+	if err != nil {
+		app.syndicationError(w, err, "Tag not found")
+		return
+	}
+
+	app.publish(w, r, &syndication.Channel{
+		Title:       "#" + slug,
+		Description: "The latest posts tagged #" + slug,
+		Link:        app.config.apiURL + "/v1/tags/" + slug + "/posts",
+	}, posts)
+}
+
+// enclosures lists the media of a post as feed readers, who are not
+// signed in, can get them: videos as they are and images by their
+// largest variant, once made.
+func (app *Application) enclosures(attached []database.Media) []syndication.Enclosure {
+	app.setMediaURLs(0, attached)
+
+	var output []syndication.Enclosure
+	for _, m := range attached {
+		e := syndication.Enclosure{URL: m.URL, Type: m.MIME, Length: m.Size}
+		if n := len(m.Variants); n > 0 {
+			// variants are ordered by width
+			v := m.Variants[n-1]
+			e = syndication.Enclosure{URL: v.URL, Type: v.MIME, Length: v.Size}
+		}
+		if e.URL != "" {
+			output = append(output, e)
+		}
+	}
+	return output
+}
+
+func (app *Application) syndicationError(w http.ResponseWriter, err error, notFound string) {
+	if errors.Is(err, database.ErrNotFound) {
+		jsonResponse(w, http.StatusNotFound, Response{Message: notFound})
+		return
+	}
+	log.Printf("DB error: %v\n", err.Error())
+	jsonResponse(w, http.StatusInternalServerError, Response{Message: "Server error"})
+}
+
+// publish renders posts into ch in the format of the URL, caches the
+// document and serves it. The feed was last modified when its most
+// recently updated post was.
+func (app *Application) publish(w http.ResponseWriter, r *http.Request,
+	ch *syndication.Channel, posts []database.SyndicatedPost) {
+	ch.Self = app.config.apiURL + r.URL.Path
+	for _, p := range posts {
+		link := app.config.apiURL + "/v1/post/" + strconv.FormatInt(p.ID, 10)
+		ch.Items = append(ch.Items, syndication.Item{
+			ID:         link,
+			Title:      p.Title,
+			Link:       link,
+			Author:     p.Author,
+			Content:    p.Content,
+			Enclosures: app.enclosures(p.Media),
+			Published:  p.CreatedAt,
+			Updated:    p.UpdatedAt,
+		})
+		if p.UpdatedAt.After(ch.Updated) {
+			ch.Updated = p.UpdatedAt
+		}
+	}
+
+	modified := ch.Updated
+	if modified.IsZero() {
+		// nothing was posted yet, the feed is only as old as this response
+		ch.Updated = time.Now()
+	}
+
+	format := chi.URLParam(r, "format")
+	render := ch.RSS
+	if format == "atom" {
+		render = ch.Atom
+	}
+	body, err := render()
+	if err != nil {
+		log.Printf("Error while encoding feed: %v\n", err.Error())
+		jsonResponse(w, http.StatusInternalServerError, Response{Message: "Server error"})
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	doc := &syndicated{
+		body:        body,
+		contentType: contentTypes[format],
+		etag:        `"` + hex.EncodeToString(sum[:16]) + `"`,
+		modified:    modified,
+	}
+	app.syndication.Set(r.URL.Path, doc)
+	serveSyndicated(w, r, doc)
+}
+
+// serveSyndicated writes doc, or only 304 Not Modified when the reader
+// already has it as told by If-None-Match or If-Modified-Since.
+func serveSyndicated(w http.ResponseWriter, r *http.Request, doc *syndicated) {
+	w.Header().Set("Content-Type", doc.contentType)
+	w.Header().Set("ETag", doc.etag)
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(syndicationTTL.Seconds())))
+	http.ServeContent(w, r, "", doc.modified, bytes.NewReader(doc.body))
+}
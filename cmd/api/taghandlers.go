@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+	"github.com/Alter-Sitanshu/learning_Go/internal/entities"
+	"github.com/go-chi/chi/v5"
+)
+
+// parseTag returns the slug of the tag of the URL, a leading # and any
+// case are accepted.
+func parseTag(r *http.Request) (string, error) {
+	slug := entities.Slug(chi.URLParam(r, "tag"))
+	if slug == "" {
+		return "", errors.New("tag should contain a letter")
+	}
+	return slug, nil
+}
+
+func (app *Application) GetTagPostsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	res := Response{}
+	slug, err := parseTag(r)
+	if err != nil {
+		res.Message = "Bad request: " + err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	page, err := app.parsePage(r, "tag_posts", 20, 50)
+	if err != nil {
+		res.Message = "Bad request: " + err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	posts, err := app.store.Tag().GetPosts(r.Context(), slug, user.ID, page.After, page.Limit)
+	if err != nil {
+		log.Printf("DB error: %v\n", err.Error())
+		res.Message = "Server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+	for i := range posts {
+		app.setMediaURLs(user.ID, posts[i].Media)
+	}
+
+	var next string
+	if n := len(posts); n > 0 {
+		next = page.nextCursor(n, cursor.Key{Time: posts[n-1].TaggedAt, ID: posts[n-1].ID})
+	}
+	paginatedResponse(w, http.StatusOK, posts, next, "")
+}
+
+func (app *Application) FollowTagHandler(w http.ResponseWriter, r *http.Request) {
+	app.updateTagFollow(w, r, app.store.Tag().Follow, "Tag followed")
+}
+
+func (app *Application) UnfollowTagHandler(w http.ResponseWriter, r *http.Request) {
+	app.updateTagFollow(w, r, app.store.Tag().Unfollow, "Tag unfollowed")
+}
+
+// updateTagFollow makes the current user follow, or stop following, the
+// tag of the URL.
+func (app *Application) updateTagFollow(w http.ResponseWriter, r *http.Request,
+	update func(context.Context, int64, string) error, message string) {
+	user := getUserFromCtx(r)
+	res := Response{}
+	slug, err := parseTag(r)
+	if err != nil {
+		res.Message = "Bad request: " + err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	if err := update(r.Context(), user.ID, slug); err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			res.Message = "Tag not found"
+			jsonResponse(w, http.StatusNotFound, res)
+		default:
+			log.Printf("DB error: %v\n", err.Error())
+			res.Message = "Server error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+		}
+		return
+	}
+
+	res.Message = message
+	jsonResponse(w, http.StatusOK, res)
+}
+
+func (app *Application) GetFollowedTagsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	tags, err := app.store.Tag().GetFollowed(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("DB error: %v\n", err.Error())
+		jsonResponse(w, http.StatusInternalServerError, Response{Message: "Server error"})
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, tags)
+}
+
+func (app *Application) GetTrendingTagsHandler(w http.ResponseWriter, r *http.Request) {
+	res := Response{}
+	page, err := app.parsePage(r, "trending", 10, database.MaxTrending)
+	if err != nil {
+		res.Message = "Bad request: " + err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	tags, err := app.store.Tag().GetTrending(r.Context(), page.Limit)
+	if err != nil {
+		log.Printf("DB error: %v\n", err.Error())
+		res.Message = "Server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, tags)
+}
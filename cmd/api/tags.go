@@ -0,0 +1,36 @@
+package main
+
+import "github.com/Alter-Sitanshu/learning_Go/internal/entities"
+
+// mergeTags appends the hashtags that are not already part of tags, every
+// tag is turned into its slug and those that have none are dropped.
+func mergeTags(tags, hashtags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	var output []string
+	for _, tag := range append(tags, hashtags...) {
+		slug := entities.Slug(tag)
+		if slug != "" && !seen[slug] {
+			seen[slug] = true
+			output = append(output, slug)
+		}
+	}
+	return output
+}
+
+// retag gives the hashtags of oldContent among tags way to those of
+// newContent, the tags given along with the post are kept.
+func retag(tags []string, oldContent, newContent string) []string {
+	oldEnts := entities.Parse(oldContent)
+	dropped := make(map[string]bool)
+	for _, tag := range oldEnts.Tags() {
+		dropped[entities.Slug(tag)] = true
+	}
+	var kept []string
+	for _, tag := range tags {
+		if !dropped[tag] {
+			kept = append(kept, tag)
+		}
+	}
+	newEnts := entities.Parse(newContent)
+	return mergeTags(kept, newEnts.Tags())
+}
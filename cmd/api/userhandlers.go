@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -8,7 +9,9 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
 	"github.com/Alter-Sitanshu/learning_Go/internal/database"
 	"github.com/Alter-Sitanshu/learning_Go/internal/mailer"
 	"github.com/go-chi/chi/v5"
@@ -26,16 +29,89 @@ type UserPayload struct {
 	Email    string `json:"email" validate:"min=12"`
 }
 
+// Payload struct for updating the profile of the current user
+type ProfileMutate struct {
+	Name        *string `json:"name" validate:"omitempty,min=1,max=255"`
+	DisplayName *string `json:"display_name" validate:"omitempty,max=100"`
+	Bio         *string `json:"bio" validate:"omitempty,max=300"`
+	AvatarURL   *string `json:"avatar_url" validate:"omitempty,http_url,max=2048"`
+	Age         *int    `json:"age" validate:"omitempty,min=1,max=100"`
+	Gender      *byte   `json:"gender" validate:"omitempty,oneof=0 1"`
+	Private     *bool   `json:"is_private"`
+}
+
+type PasswordPayload struct {
+	Current string `json:"current_password" validate:"required,max=72"`
+	New     string `json:"new_password" validate:"min=8,max=72"`
+}
+
+type EmailPayload struct {
+	Email string `json:"email" validate:"min=12,email"`
+}
+
 func getUserFromCtx(r *http.Request) *database.User {
 	user, _ := r.Context().Value(userctx).(*database.User)
 	return user
 }
 
+// viewerOf returns the ID of the signed in user, 0 on the routes open to
+// anyone.
+func viewerOf(r *http.Request) int64 {
+	if user := getUserFromCtx(r); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+// parseUserID reads the userID URL parameter.
+func parseUserID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+}
+
+// expiresIn spells out how long the token of a mail is valid for: in days
+// when d is a whole number of days, else in hours or minutes.
+func expiresIn(d time.Duration) string {
+	n, unit := int(d.Round(time.Minute)/time.Minute), "minute"
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		n, unit = int(d/(24*time.Hour)), "day"
+	case d >= time.Hour && d%time.Hour == 0:
+		n, unit = int(d/time.Hour), "hour"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+// writeFollowError maps the errors of following a user to responses.
+func writeFollowError(w http.ResponseWriter, err error) {
+	res := Response{
+		Message: err.Error(),
+	}
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		res.Message = "User not found"
+		jsonResponse(w, http.StatusNotFound, res)
+	case errors.Is(err, database.ErrSelfFollow):
+		jsonResponse(w, http.StatusBadRequest, res)
+	case errors.Is(err, database.ErrBlocked):
+		jsonResponse(w, http.StatusForbidden, res)
+	case errors.Is(err, database.ErrAlreadyFollowing),
+		errors.Is(err, database.ErrAlreadyRequested):
+		jsonResponse(w, http.StatusConflict, res)
+	default:
+		log.Printf("DB error: %v\n", err.Error())
+		res.Message = "Server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+	}
+}
+
 func (app *Application) GetUserHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	userid := chi.URLParam(r, "userID")
-	id, err := strconv.ParseInt(userid, 10, 64)
+	viewer := getUserFromCtx(r)
 	res := Response{}
+	id, err := parseUserID(r)
 	if err != nil {
 		log.Println("Could not parse user id.")
 		res.Message = "id should only contain integers."
@@ -43,7 +119,7 @@ func (app *Application) GetUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := app.store.User().GetUserByID(ctx, id)
+	profile, err := app.store.User().GetProfile(ctx, id, viewer.ID)
 	if err != nil {
 		log.Printf("DB error: %v\n", err.Error())
 		switch {
@@ -57,50 +133,191 @@ func (app *Application) GetUserHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	jsonResponse(w, http.StatusOK, user)
+	jsonResponse(w, http.StatusOK, profile)
 }
 
 func (app *Application) FollowUser(w http.ResponseWriter, r *http.Request) {
-	targetID, _ := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
 	user := getUserFromCtx(r)
+	res := Response{}
+	targetID, err := parseUserID(r)
+	if err != nil {
+		res.Message = "id should only contain integers."
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
 
-	res := Response{
-		Message: fmt.Sprintf("Followed user with id: %d", targetID),
+	ctx := r.Context()
+	requested, err := app.store.User().Follow(ctx, targetID, user.ID)
+	if err != nil {
+		writeFollowError(w, err)
+		return
+	}
+
+	// private accounts have to approve the request first
+	if requested {
+		res.Message = fmt.Sprintf("Follow request sent to user with id: %d", targetID)
+		jsonResponse(w, http.StatusAccepted, res)
+		return
+	}
+	res.Message = fmt.Sprintf("Followed user with id: %d", targetID)
+	jsonResponse(w, http.StatusOK, res)
+}
+
+func (app *Application) UnfollowUser(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	res := Response{}
+	targetID, err := parseUserID(r)
+	if err != nil {
+		res.Message = "id should only contain integers."
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
 	}
 
 	ctx := r.Context()
-	err := app.store.User().Follow(ctx, targetID, user.ID)
+	if err := app.store.User().Unfollow(ctx, targetID, user.ID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			res.Message = "You do not follow this user"
+			jsonResponse(w, http.StatusNotFound, res)
+			return
+		}
+		writeFollowError(w, err)
+		return
+	}
+
+	res.Message = fmt.Sprintf("Unfollowed user with id: %d", targetID)
+	jsonResponse(w, http.StatusOK, res)
+}
+
+func (app *Application) GetFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	app.listFollows(w, r, "followers", app.store.User().GetFollowers)
+}
+
+func (app *Application) GetFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	app.listFollows(w, r, "following", app.store.User().GetFollowing)
+}
+
+type followLister func(context.Context, int64, int64, cursor.Key, int) ([]database.FollowEntry, error)
+
+func (app *Application) listFollows(w http.ResponseWriter, r *http.Request, name string, list followLister) {
+	res := Response{}
+	id, err := parseUserID(r)
 	if err != nil {
-		log.Printf("Error: %v\n", err.Error())
-		res.Message = err.Error()
+		res.Message = "id should only contain integers."
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	page, err := app.parsePage(r, name, 20, 100)
+	if err != nil {
+		res.Message = "Bad request: " + err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	user := getUserFromCtx(r)
+	entries, err := list(r.Context(), id, user.ID, page.After, page.Limit)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			res.Message = "User not found"
+			jsonResponse(w, http.StatusNotFound, res)
+			return
+		}
+		log.Printf("DB error: %v\n", err.Error())
+		res.Message = "Server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	var next string
+	if n := len(entries); n > 0 {
+		last := entries[n-1]
+		next = page.nextCursor(n, cursor.Key{Time: last.FollowedAt, ID: last.ID})
+	}
+	paginatedResponse(w, http.StatusOK, entries, next, "")
+}
+
+func (app *Application) GetFollowRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	res := Response{}
+	page, err := app.parsePage(r, "follow_requests", 20, 100)
+	if err != nil {
+		res.Message = "Bad request: " + err.Error()
 		jsonResponse(w, http.StatusBadRequest, res)
+		return
 	}
 
-	jsonResponse(w, http.StatusNoContent, res)
+	requests, err := app.store.User().GetFollowRequests(r.Context(), user.ID, page.After, page.Limit)
+	if err != nil {
+		log.Printf("DB error: %v\n", err.Error())
+		res.Message = "Server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	var next string
+	if n := len(requests); n > 0 {
+		last := requests[n-1]
+		next = page.nextCursor(n, cursor.Key{Time: last.FollowedAt, ID: last.ID})
+	}
+	paginatedResponse(w, http.StatusOK, requests, next, "")
 }
 
-func (app *Application) UnfollowUser(w http.ResponseWriter, r *http.Request) {
-	targetID, _ := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+func (app *Application) ApproveFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromCtx(r)
+	res := Response{}
+	requesterID, err := parseUserID(r)
+	if err != nil {
+		res.Message = "id should only contain integers."
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
 
-	res := Response{
-		Message: fmt.Sprintf("Unfollowed user with id: %d", targetID),
+	err = app.store.User().ApproveFollowRequest(r.Context(), user.ID, requesterID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			res.Message = "Follow request not found"
+			jsonResponse(w, http.StatusNotFound, res)
+			return
+		}
+		writeFollowError(w, err)
+		return
 	}
 
-	ctx := r.Context()
-	err := app.store.User().Unfollow(ctx, targetID, user.ID)
+	res.Message = fmt.Sprintf("Approved follow request of user with id: %d", requesterID)
+	jsonResponse(w, http.StatusOK, res)
+}
+
+func (app *Application) RejectFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	res := Response{}
+	requesterID, err := parseUserID(r)
 	if err != nil {
-		log.Printf("Error: %v\n", err.Error())
-		res.Message = err.Error()
+		res.Message = "id should only contain integers."
 		jsonResponse(w, http.StatusBadRequest, res)
+		return
 	}
 
-	jsonResponse(w, http.StatusNoContent, res)
+	err = app.store.User().RejectFollowRequest(r.Context(), user.ID, requesterID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			res.Message = "Follow request not found"
+			jsonResponse(w, http.StatusNotFound, res)
+			return
+		}
+		writeFollowError(w, err)
+		return
+	}
+
+	res.Message = fmt.Sprintf("Rejected follow request of user with id: %d", requesterID)
+	jsonResponse(w, http.StatusOK, res)
 }
 
 func (app *Application) GetFeedHandler(w http.ResponseWriter, r *http.Request) {
 	userid := getUserFromCtx(r).ID
-	fq := &database.FilteringQuery{}
+	fq := &database.FilteringQuery{
+		Limit:   20,
+		Sort:    "desc",
+		Weights: app.config.ranking,
+	}
 	res := Response{
 		Message: "Feed fetched",
 	}
@@ -117,9 +334,68 @@ func (app *Application) GetFeedHandler(w http.ResponseWriter, r *http.Request) {
 		jsonResponse(w, http.StatusBadRequest, res)
 		return
 	}
+	ranked := fq.Sort == database.SortTop || fq.Sort == database.SortRelevant
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		// Scores change between requests, ranked feeds are paged at an offset
+		if ranked {
+			res.Message = "Bad request: cursors only page chronological feeds"
+			jsonResponse(w, http.StatusBadRequest, res)
+			return
+		}
+		key, err := app.cursors.Decode(c, "feed", fq.Sort)
+		if err != nil {
+			res.Message = "Bad request: " + err.Error()
+			jsonResponse(w, http.StatusBadRequest, res)
+			return
+		}
+		fq.Cursor = &key
+	}
 
 	feed, err := app.store.User().GetFeed(r.Context(), userid, fq)
 
+	if err != nil {
+		log.Printf("Server Error: %v\n", err.Error())
+		res.Message = "server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+	app.setFeedMediaURLs(userid, feed)
+	if ranked {
+		paginatedResponse(w, http.StatusOK, feed, "", "")
+		return
+	}
+
+	// The previous page is always offered, scrolling back from the top
+	// fetches the entries that arrived since.
+	var next, prev string
+	back := fq.Cursor != nil && fq.Cursor.Back
+	if n := len(feed); n > 0 {
+		first, last := feed[0], feed[n-1]
+		if n == fq.Limit || back {
+			next = app.cursors.Encode(cursor.Key{List: "feed", Sort: fq.Sort, Time: last.At, ID: last.Post.ID})
+		}
+		prev = app.cursors.Encode(cursor.Key{
+			List: "feed", Sort: fq.Sort, Time: first.At, ID: first.Post.ID, Back: true,
+		})
+	} else if fq.Cursor != nil {
+		key := *fq.Cursor
+		key.Back = true
+		prev = app.cursors.Encode(key)
+	}
+	paginatedResponse(w, http.StatusOK, feed, next, prev)
+}
+
+func (app *Application) GetSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	res := Response{}
+	page, err := app.parsePage(r, "suggestions", 10, database.MaxSuggestions)
+	if err != nil {
+		res.Message = "Bad request: " + err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	suggestions, err := app.store.User().GetSuggestions(r.Context(), user.ID, page.Limit)
 	if err != nil {
 		log.Printf("Server Error: %v\n", err.Error())
 		res.Message = "server error"
@@ -127,7 +403,38 @@ func (app *Application) GetFeedHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jsonResponse(w, http.StatusOK, feed)
+	jsonResponse(w, http.StatusOK, suggestions)
+}
+
+func (app *Application) SearchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	uq := &database.UserSearchQuery{
+		Limit: 20,
+	}
+	res := Response{}
+	err := uq.Parse(r)
+	if err != nil {
+		log.Printf("Bad Request: %v\n", err.Error())
+		res.Message = "Bad request: Error while parsing"
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	if err = Validate.Struct(uq); err != nil {
+		log.Printf("Bad Request: %v\n", err.Error())
+		res.Message = err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	users, err := app.store.User().SearchUsers(r.Context(), user.ID, uq)
+	if err != nil {
+		log.Printf("Server Error: %v\n", err.Error())
+		res.Message = "server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, users)
 }
 
 func (app *Application) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -203,7 +510,7 @@ func (app *Application) CreateUserHandler(w http.ResponseWriter, r *http.Request
 	req := mailer.EmailRequest{
 		To:      user.Email,
 		Subject: "Auth-Bearer/Token",
-		Body:    fmt.Sprintf("Your user verification token is: %v\nExpires in: 3 days", plainToken),
+		Body:    fmt.Sprintf("Your user verification token is: %v\nExpires in: %s", plainToken, expiresIn(app.config.mail.Expiry)),
 	}
 	err = app.mailer.SendEmail(req)
 	if err != nil {
@@ -216,17 +523,207 @@ func (app *Application) CreateUserHandler(w http.ResponseWriter, r *http.Request
 
 }
 
+// DeleteUserHandler deactivates the account of the user, it is deleted for
+// good once the grace period is over unless they log back in.
 func (app *Application) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromCtx(r)
-	err := app.store.User().DeleteUser(r.Context(), user)
+	err := app.store.User().DeactivateUser(r.Context(), user)
+	res := Response{}
+	if err != nil {
+		log.Printf("server error: %v\n", err.Error())
+		res.Message = "internal server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	deadline := user.DeactivatedAt.Add(app.config.deletionGrace)
+	res.Message = fmt.Sprintf("Account deactivated, it will be deleted on %s unless you log in before",
+		deadline.Format(time.RFC1123))
+	jsonResponse(w, http.StatusAccepted, res)
+}
+
+func (app *Application) SuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.updateSuspension(w, r, app.store.User().SuspendUser, "User suspended")
+}
+
+func (app *Application) UnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.updateSuspension(w, r, app.store.User().UnsuspendUser, "User unsuspended")
+}
+
+// updateSuspension suspends the user of the URL, or lifts their
+// suspension, on behalf of the current moderator.
+func (app *Application) updateSuspension(w http.ResponseWriter, r *http.Request,
+	update func(context.Context, int64, int) error, message string) {
+	user := getUserFromCtx(r)
 	res := Response{}
+	targetID, err := parseUserID(r)
+	if err != nil {
+		res.Message = "id should only contain integers."
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	if err := update(r.Context(), targetID, user.Role); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			res.Message = "User not found"
+			jsonResponse(w, http.StatusNotFound, res)
+			return
+		}
+		log.Printf("DB error: %v\n", err.Error())
+		res.Message = "Server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	res.Message = message
+	jsonResponse(w, http.StatusOK, res)
+}
+
+func (app *Application) UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	var payload ProfileMutate
+	res := Response{}
+
+	if err := ReadJSON(w, r, &payload); err != nil {
+		res.Message = "Incorrect data format"
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		res.Message = err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	if payload.Name != nil {
+		user.Name = *payload.Name
+	}
+	if payload.DisplayName != nil {
+		user.DisplayName = *payload.DisplayName
+	}
+	if payload.Bio != nil {
+		user.Bio = *payload.Bio
+	}
+	if payload.AvatarURL != nil {
+		user.AvatarURL = *payload.AvatarURL
+	}
+	if payload.Age != nil {
+		user.Age = *payload.Age
+	}
+	if payload.Gender != nil {
+		user.Gender = *payload.Gender
+	}
+	if payload.Private != nil {
+		user.Private = *payload.Private
+	}
+
+	err := app.store.User().UpdateUser(r.Context(), user)
 	if err != nil {
+		switch err {
+		case database.ErrDupliName:
+			res.Message = "name taken"
+			jsonResponse(w, http.StatusBadRequest, res)
+		default:
+			log.Printf("DB error: %v\n", err.Error())
+			res.Message = "internal server error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+		}
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, user)
+}
+
+func (app *Application) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	var payload PasswordPayload
+	res := Response{
+		Message: "password changed",
+	}
+
+	if err := ReadJSON(w, r, &payload); err != nil {
+		res.Message = "Incorrect data format"
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		res.Message = err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	if err := user.Password.CheckPassword(payload.Current); err != nil {
+		res.Message = "invalid credentials"
+		jsonResponse(w, http.StatusUnauthorized, res)
+		return
+	}
+	if err := user.Password.Hash(payload.New); err != nil {
 		log.Printf("server error: %v\n", err.Error())
 		res.Message = "internal server error"
 		jsonResponse(w, http.StatusInternalServerError, res)
 		return
 	}
 
-	res.Message = "User Deleted"
-	jsonResponse(w, http.StatusNoContent, res)
+	if err := app.store.User().UpdatePassword(r.Context(), user); err != nil {
+		log.Printf("DB error: %v\n", err.Error())
+		res.Message = "internal server error"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// ChangeEmailHandler mails a confirmation token to the new address, the
+// email of the user only changes once ConfirmEmailHandler receives it.
+func (app *Application) ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+	ctx := r.Context()
+	var payload EmailPayload
+	res := Response{
+		Message: "confirmation sent to the new email",
+	}
+
+	if err := ReadJSON(w, r, &payload); err != nil {
+		res.Message = "Incorrect data format"
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		res.Message = err.Error()
+		jsonResponse(w, http.StatusBadRequest, res)
+		return
+	}
+
+	plainToken := uuid.New().String()
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	err := app.store.User().RequestEmailChange(ctx, user.ID, payload.Email, hashToken, app.config.mail.Expiry)
+	if err != nil {
+		switch err {
+		case database.ErrDupliMail:
+			res.Message = "email already exists"
+			jsonResponse(w, http.StatusBadRequest, res)
+		default:
+			log.Printf("DB error: %v\n", err.Error())
+			res.Message = "internal server error"
+			jsonResponse(w, http.StatusInternalServerError, res)
+		}
+		return
+	}
+
+	req := mailer.EmailRequest{
+		To:      payload.Email,
+		Subject: "Confirm your new email",
+		Body:    fmt.Sprintf("Your email confirmation token is: %v\nExpires in: %s", plainToken, expiresIn(app.config.mail.Expiry)),
+	}
+	if err = app.mailer.SendEmail(req); err != nil {
+		log.Printf("encountered error sending mail: %v\n", err.Error())
+		res.Message = "error sending email, retry"
+		jsonResponse(w, http.StatusInternalServerError, res)
+		return
+	}
+
+	jsonResponse(w, http.StatusAccepted, res)
 }
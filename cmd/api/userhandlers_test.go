@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiresIn(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{time.Hour * 24 * 3, "3 days"},
+		{time.Hour * 24, "1 day"},
+		{time.Hour * 36, "36 hours"},
+		{time.Hour, "1 hour"},
+		{time.Minute * 90, "90 minutes"},
+		{time.Minute * 15, "15 minutes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := expiresIn(tt.d); got != tt.want {
+				t.Errorf("expiresIn(%v) = %q, want %q", tt.d, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+	wsMaxMessage = 8192
+)
+
+var errUnknownFrame = errors.New("unknown frame type")
+
+// wsProtocol is the subprotocol browsers offer along with their bearer
+// token, as in new WebSocket(url, ["bearer", token]).
+const wsProtocol = "bearer"
+
+// checkOrigin only lets the configured origins open the gateway from a
+// browser. Clients other than browsers send no Origin.
+func (app *Application) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || slices.Contains(app.config.wsOrigins, origin)
+}
+
+// wsFrame is the envelope of every frame exchanged over the gateway.
+// Clients send "message", "typing" and "read" frames; the server pushes
+// stream events and "error" frames.
+type wsFrame struct {
+	ID             int64           `json:"id,omitempty"`
+	Type           string          `json:"type"`
+	ConversationID int64           `json:"conversation_id,omitempty"`
+	MessageID      int64           `json:"message_id,omitempty"`
+	Content        string          `json:"content,omitempty"`
+	Payload        json.RawMessage `json:"payload,omitempty"`
+}
+
+// WSTokenMiddleware lets browsers, which can not set headers on a
+// WebSocket handshake, pass the bearer token as the second of the
+// subprotocols they offer, after wsProtocol. Unlike a query parameter the
+// header never reaches the access log.
+func WSTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocols := websocket.Subprotocols(r)
+		if len(protocols) == 2 && protocols[0] == wsProtocol && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+protocols[1])
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WebSocketHandler is the live gateway for direct messages. It relays the
+// user's stream events and accepts messages, typing indicators and read
+// receipts.
+func (app *Application) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromCtx(r)
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		// only wsProtocol is echoed back, never the token
+		Subprotocols: []string{wsProtocol},
+		CheckOrigin:  app.checkOrigin,
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied to the client
+		log.Printf("websocket upgrade: %v\n", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	sub := app.hub.Subscribe(user.ID)
+	defer sub.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan wsFrame, 8)
+	go app.wsReadPump(ctx, cancel, conn, user, errs)
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	for {
+		var frame any
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev, ok := <-sub.C:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "reconnect"),
+					time.Now().Add(wsWriteWait))
+				return
+			}
+			frame = wsFrame{ID: ev.Seq, Type: ev.Type, Payload: ev.Payload}
+
+		case f := <-errs:
+			frame = f
+
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+			continue
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := conn.WriteJSON(frame); err != nil {
+			return
+		}
+	}
+}
+
+func (app *Application) wsReadPump(ctx context.Context, cancel context.CancelFunc,
+	conn *websocket.Conn, user *database.User, errs chan<- wsFrame) {
+	defer cancel()
+
+	conn.SetReadLimit(wsMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var frame wsFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("websocket read: %v\n", err.Error())
+			}
+			return
+		}
+
+		if err := app.handleFrame(ctx, user, &frame); err != nil {
+			res := Response{Message: err.Error()}
+			switch {
+			case errors.Is(err, database.ErrNotFound):
+				res.Message = "Conversation not found"
+			case errors.Is(err, database.ErrDMNotAllowed),
+				errors.Is(err, errUnknownFrame),
+				errors.As(err, new(validator.ValidationErrors)):
+			default:
+				log.Printf("DB error: %v\n", err.Error())
+				res.Message = "server error"
+			}
+			msg, _ := json.Marshal(res)
+			select {
+			case errs <- wsFrame{Type: "error", ConversationID: frame.ConversationID, Payload: msg}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (app *Application) handleFrame(ctx context.Context, user *database.User, frame *wsFrame) error {
+	convs := app.store.Conversation()
+
+	switch frame.Type {
+	case database.EventMessage:
+		payload := MessagePayload{Content: frame.Content}
+		if err := Validate.Struct(payload); err != nil {
+			return err
+		}
+		return convs.SendMessage(ctx, &database.Message{
+			ConversationID: frame.ConversationID,
+			SenderID:       user.ID,
+			Content:        payload.Content,
+		})
+
+	case database.EventTyping:
+		return convs.Typing(ctx, &database.Typing{
+			ConversationID: frame.ConversationID,
+			UserID:         user.ID,
+		})
+
+	case database.EventRead:
+		return convs.MarkRead(ctx, &database.ReadReceipt{
+			ConversationID: frame.ConversationID,
+			UserID:         user.ID,
+			MessageID:      frame.MessageID,
+		})
+	}
+
+	return errUnknownFrame
+}
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS reposts;
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS reposts(
+    id BIGSERIAL PRIMARY KEY,
+    postid BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    userid BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    quote VARCHAR(300),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    UNIQUE(postid, userid)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reposts_userid ON reposts(userid, created_at);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS mentions(
+    id BIGSERIAL PRIMARY KEY,
+    userid BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    author_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    postid BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    commentid BIGINT REFERENCES comments(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mentions_userid ON mentions(userid, created_at);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS reactions(
+    postid BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    userid BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(10) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY(postid, userid)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reactions_userid ON reactions(userid);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS notifications(
+    id BIGSERIAL PRIMARY KEY,
+    userid BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    actor_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL,
+    postid BIGINT REFERENCES posts(id) ON DELETE CASCADE,
+    commentid BIGINT REFERENCES comments(id) ON DELETE CASCADE,
+    read_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_userid ON notifications(userid, id DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(userid, id DESC)
+WHERE read_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS notification_preferences(
+    userid BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+
+    PRIMARY KEY(userid, type)
+);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS stream_events;
\ No newline at end of file
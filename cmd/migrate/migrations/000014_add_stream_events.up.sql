@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS stream_events(
+    id BIGSERIAL PRIMARY KEY,
+    userid BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_stream_events_userid ON stream_events(userid, id);
+CREATE INDEX IF NOT EXISTS idx_stream_events_created_at ON stream_events(created_at);
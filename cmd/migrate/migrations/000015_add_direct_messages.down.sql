@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversation_members;
+DROP TABLE IF EXISTS conversations;
+
+ALTER TABLE users
+DROP COLUMN dm_mutuals_only;
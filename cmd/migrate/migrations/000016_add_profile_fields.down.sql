@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS email_tokens;
+
+ALTER TABLE users
+DROP COLUMN avatar_url,
+DROP COLUMN bio,
+DROP COLUMN display_name;
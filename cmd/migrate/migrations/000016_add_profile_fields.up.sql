@@ -0,0 +1,11 @@
+ALTER TABLE users
+ADD COLUMN display_name VARCHAR(100),
+ADD COLUMN bio VARCHAR(300),
+ADD COLUMN avatar_url VARCHAR(2048);
+
+CREATE TABLE IF NOT EXISTS email_tokens(
+    userid BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token BYTEA NOT NULL UNIQUE,
+    email CITEXT NOT NULL,
+    expiry TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_followers_follower_id;
+DROP INDEX IF EXISTS idx_followers_userid;
+
+ALTER TABLE followers
+DROP COLUMN created_at;
@@ -0,0 +1,5 @@
+ALTER TABLE followers
+ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS idx_followers_userid ON followers(userid, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_followers_follower_id ON followers(follower_id, created_at DESC);
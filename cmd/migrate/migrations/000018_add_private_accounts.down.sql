@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS follow_requests;
+
+ALTER TABLE users
+DROP COLUMN is_private;
@@ -0,0 +1,14 @@
+ALTER TABLE users
+ADD COLUMN is_private BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS follow_requests(
+    userid BIGINT NOT NULL,
+    requester_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY(userid, requester_id),
+    FOREIGN KEY (userid) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (requester_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_follow_requests_userid ON follow_requests(userid, created_at DESC);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_users_display_name_trgm;
+DROP INDEX IF EXISTS idx_users_name_trgm;
+
+ALTER TABLE users
+DROP COLUMN suspended_at;
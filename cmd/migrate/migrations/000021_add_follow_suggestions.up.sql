@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS follow_suggestions(
+    userid BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    suggested_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    mutual_count INT NOT NULL DEFAULT 0,
+    score DOUBLE PRECISION NOT NULL,
+    reason VARCHAR(20) NOT NULL,
+    computed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY(userid, suggested_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_follow_suggestions_score ON follow_suggestions(userid, score DESC);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_users_deactivated_at;
+
+ALTER TABLE users
+DROP COLUMN deactivated_at;
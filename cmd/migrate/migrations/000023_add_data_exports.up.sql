@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS data_exports(
+    id BIGSERIAL PRIMARY KEY,
+    userid BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending',
+    token BYTEA UNIQUE,
+    path TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ
+);
+
+-- a user can only have one export in progress
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_in_progress ON data_exports(userid)
+WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_data_exports_pending ON data_exports(created_at)
+WHERE status = 'pending';
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS timeline_queue;
+DROP TABLE IF EXISTS timelines;
+
+DROP TRIGGER IF EXISTS reposts_count ON reposts;
+DROP TRIGGER IF EXISTS comments_count ON comments;
+DROP TRIGGER IF EXISTS followers_count ON followers;
+DROP FUNCTION IF EXISTS count_reposts();
+DROP FUNCTION IF EXISTS count_comments();
+DROP FUNCTION IF EXISTS count_followers();
+
+ALTER TABLE posts
+DROP COLUMN repost_count,
+DROP COLUMN comment_count;
+
+ALTER TABLE users
+DROP COLUMN follower_count;
@@ -0,0 +1,92 @@
+-- Denormalised counters, kept up to date by triggers so that reads do not
+-- have to aggregate.
+ALTER TABLE users
+ADD COLUMN follower_count INT NOT NULL DEFAULT 0;
+
+ALTER TABLE posts
+ADD COLUMN comment_count INT NOT NULL DEFAULT 0,
+ADD COLUMN repost_count INT NOT NULL DEFAULT 0;
+
+UPDATE users u
+SET follower_count = (SELECT COUNT(*) FROM followers f WHERE f.userid = u.id);
+
+UPDATE posts p
+SET comment_count = (SELECT COUNT(*) FROM comments c WHERE c.postid = p.id),
+repost_count = (SELECT COUNT(*) FROM reposts r WHERE r.postid = p.id);
+
+CREATE OR REPLACE FUNCTION count_followers() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE users SET follower_count = follower_count + 1 WHERE id = NEW.userid;
+    ELSE
+        UPDATE users SET follower_count = follower_count - 1 WHERE id = OLD.userid;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER followers_count
+AFTER INSERT OR DELETE ON followers
+FOR EACH ROW EXECUTE FUNCTION count_followers();
+
+CREATE OR REPLACE FUNCTION count_comments() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE posts SET comment_count = comment_count + 1 WHERE id = NEW.postid;
+    ELSE
+        UPDATE posts SET comment_count = comment_count - 1 WHERE id = OLD.postid;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER comments_count
+AFTER INSERT OR DELETE ON comments
+FOR EACH ROW EXECUTE FUNCTION count_comments();
+
+CREATE OR REPLACE FUNCTION count_reposts() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE posts SET repost_count = repost_count + 1 WHERE id = NEW.postid;
+    ELSE
+        UPDATE posts SET repost_count = repost_count - 1 WHERE id = OLD.postid;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER reposts_count
+AFTER INSERT OR DELETE ON reposts
+FOR EACH ROW EXECUTE FUNCTION count_reposts();
+
+-- A timeline row is an activity, a post or a repost by actor_id, in the
+-- home feed of userid.
+CREATE TABLE IF NOT EXISTS timelines(
+    userid BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    postid BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    actor_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    at TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY(userid, postid, actor_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_timelines_userid_at ON timelines(userid, at DESC);
+CREATE INDEX IF NOT EXISTS idx_timelines_actor_id ON timelines(actor_id, userid);
+
+-- Activities waiting to be fanned out to the timelines of followers
+CREATE TABLE IF NOT EXISTS timeline_queue(
+    id BIGSERIAL PRIMARY KEY,
+    postid BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    actor_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    at TIMESTAMPTZ NOT NULL
+);
+
+INSERT INTO timelines (userid, postid, actor_id, at)
+SELECT f.follower_id, p.id, p.userid, p.created_at
+FROM posts p
+JOIN followers f ON f.userid = p.userid
+UNION ALL
+SELECT f.follower_id, r.postid, r.userid, r.created_at
+FROM reposts r
+JOIN followers f ON f.userid = r.userid
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_reactions_created_at;
+DROP INDEX IF EXISTS idx_comments_userid_created_at;
+
+DROP TABLE IF EXISTS user_affinities;
+DROP TABLE IF EXISTS post_scores;
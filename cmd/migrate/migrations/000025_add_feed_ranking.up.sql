@@ -0,0 +1,23 @@
+-- Engagement of recent posts, weighted with the ranking weights of the
+-- last refresh.
+CREATE TABLE IF NOT EXISTS post_scores(
+    postid BIGINT PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+    comments INT NOT NULL,
+    reactions INT NOT NULL,
+    reposts INT NOT NULL,
+    engagement DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- How much userid interacted with the posts of author_id lately
+CREATE TABLE IF NOT EXISTS user_affinities(
+    userid BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    author_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    affinity DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY(userid, author_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_comments_userid_created_at ON comments(userid, created_at);
+CREATE INDEX IF NOT EXISTS idx_reactions_created_at ON reactions(created_at);
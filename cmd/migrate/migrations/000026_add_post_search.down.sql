@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_posts_search;
+
+ALTER TABLE posts
+DROP COLUMN search,
+DROP COLUMN language;
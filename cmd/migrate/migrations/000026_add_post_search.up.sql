@@ -0,0 +1,12 @@
+-- The text search configuration the post is stemmed with
+ALTER TABLE posts
+ADD COLUMN language REGCONFIG NOT NULL DEFAULT 'english';
+
+-- Titles weigh more than contents in the rank of a match
+ALTER TABLE posts
+ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector(language, COALESCE(title, '')), 'A') ||
+    setweight(to_tsvector(language, COALESCE(content, '')), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_posts_search ON posts USING GIN(search);
@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS tags(
+    id BIGSERIAL PRIMARY KEY,
+    slug VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- created_at is the creation time of the post, copied so that tag pages
+-- can be paged through an index.
+CREATE TABLE IF NOT EXISTS post_tags(
+    postid BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    tagid BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY(postid, tagid)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_tags_tagid_created_at ON post_tags(tagid, created_at DESC, postid DESC);
+
+CREATE TABLE IF NOT EXISTS tag_follows(
+    userid BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tagid BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY(userid, tagid)
+);
+
+-- The tags trending at the last refresh
+CREATE TABLE IF NOT EXISTS trending_tags(
+    tagid BIGINT PRIMARY KEY REFERENCES tags(id) ON DELETE CASCADE,
+    authors INT NOT NULL,
+    score DOUBLE PRECISION NOT NULL,
+    computed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO tags (slug)
+SELECT DISTINCT left(regexp_replace(lower(t.tag), '[^[:alnum:]_]', '', 'g'), 64)
+FROM posts p
+CROSS JOIN unnest(p.tags) AS t(tag)
+WHERE regexp_replace(lower(t.tag), '[^[:alnum:]_]', '', 'g') ~ '[[:alpha:]]'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO post_tags (postid, tagid, created_at)
+SELECT p.id, tg.id, p.created_at
+FROM posts p
+CROSS JOIN unnest(p.tags) AS t(tag)
+JOIN tags tg ON tg.slug = left(regexp_replace(lower(t.tag), '[^[:alnum:]_]', '', 'g'), 64)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_posts_unpublished;
+DROP INDEX IF EXISTS idx_posts_publish_at;
+
+ALTER TABLE posts
+DROP CONSTRAINT IF EXISTS posts_scheduled_publish_at,
+DROP COLUMN publish_at,
+DROP COLUMN status;
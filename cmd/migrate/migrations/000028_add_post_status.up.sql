@@ -0,0 +1,10 @@
+-- Drafts and scheduled posts are only visible to their author until they
+-- are published, publish_at is when a scheduled post goes out.
+ALTER TABLE posts
+ADD COLUMN status VARCHAR(10) NOT NULL DEFAULT 'published'
+    CHECK (status IN ('draft', 'scheduled', 'published')),
+ADD COLUMN publish_at TIMESTAMPTZ,
+ADD CONSTRAINT posts_scheduled_publish_at CHECK (status <> 'scheduled' OR publish_at IS NOT NULL);
+
+CREATE INDEX IF NOT EXISTS idx_posts_publish_at ON posts(publish_at) WHERE status = 'scheduled';
+CREATE INDEX IF NOT EXISTS idx_posts_unpublished ON posts(userid, id DESC) WHERE status <> 'published';
@@ -0,0 +1,22 @@
+-- userid is cleared when the uploader is purged, their media are then
+-- orphaned and removed along with their blobs.
+CREATE TABLE IF NOT EXISTS media(
+    id BIGSERIAL PRIMARY KEY,
+    userid BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    key TEXT NOT NULL UNIQUE,
+    kind VARCHAR(10) NOT NULL,
+    mime VARCHAR(50) NOT NULL,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_created_at ON media(created_at);
+
+-- A medium is attached to a single post, position orders the attachments.
+CREATE TABLE IF NOT EXISTS post_media(
+    postid BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    mediaid BIGINT NOT NULL UNIQUE REFERENCES media(id) ON DELETE CASCADE,
+    position INT NOT NULL,
+
+    PRIMARY KEY(postid, mediaid)
+);
@@ -0,0 +1,25 @@
+-- Images are resized into variants once uploaded, status tracks the
+-- processing and claimed_at lets a stalled run be retried.
+ALTER TABLE media
+    ADD COLUMN IF NOT EXISTS status VARCHAR(10) NOT NULL DEFAULT 'ready',
+    ADD COLUMN IF NOT EXISTS width INT,
+    ADD COLUMN IF NOT EXISTS height INT,
+    ADD COLUMN IF NOT EXISTS blurhash TEXT,
+    ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMPTZ;
+
+UPDATE media SET status = 'pending' WHERE kind = 'image';
+
+CREATE INDEX IF NOT EXISTS idx_media_pending ON media(created_at)
+WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS media_variants(
+    mediaid BIGINT NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+    name VARCHAR(10) NOT NULL,
+    key TEXT NOT NULL,
+    mime VARCHAR(50) NOT NULL,
+    width INT NOT NULL,
+    height INT NOT NULL,
+    size BIGINT NOT NULL,
+
+    PRIMARY KEY(mediaid, name)
+);
@@ -0,0 +1,11 @@
+ALTER TABLE posts
+    ADD COLUMN IF NOT EXISTS format VARCHAR(10) NOT NULL DEFAULT 'plain',
+    ADD COLUMN IF NOT EXISTS content_html TEXT NOT NULL DEFAULT '';
+
+-- Existing posts are plain text, rendered as the API renders plain posts
+UPDATE posts
+SET content_html = '<p>' || replace(
+    replace(replace(replace(replace(replace(
+        replace(content, E'\r\n', E'\n'),
+        '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;'),
+    E'\n', '<br>') || '</p>';
@@ -0,0 +1,26 @@
+-- Previews are fetched once per URL and shared by the posts linking to
+-- it, they are fetched again once stale.
+CREATE TABLE IF NOT EXISTS link_previews(
+    url TEXT PRIMARY KEY,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending',
+    title TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image TEXT NOT NULL DEFAULT '',
+    site_name TEXT NOT NULL DEFAULT '',
+    fetched_at TIMESTAMPTZ,
+    claimed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_link_previews_pending ON link_previews(created_at)
+WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS post_links(
+    postid BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    url TEXT NOT NULL REFERENCES link_previews(url) ON DELETE CASCADE,
+    position INT NOT NULL,
+
+    PRIMARY KEY(postid, url)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_links_url ON post_links(url);
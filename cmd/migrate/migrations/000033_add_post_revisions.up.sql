@@ -0,0 +1,12 @@
+-- A revision is a version of a published post as it was before an edit
+CREATE TABLE IF NOT EXISTS post_revisions(
+    postid BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL,
+    format VARCHAR(10) NOT NULL,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    edited_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY(postid, version)
+);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_stream_events_userid_seq;
+CREATE INDEX IF NOT EXISTS idx_stream_events_userid ON stream_events(userid, id);
+
+ALTER TABLE stream_events
+DROP COLUMN IF EXISTS seq;
+
+DROP TABLE IF EXISTS stream_sequences;
@@ -0,0 +1,23 @@
+-- The last sequence number drawn for the stream events of a user. Events
+-- are numbered per recipient, the row stays locked until the transaction
+-- storing an event commits, so that numbers follow commit order.
+CREATE TABLE IF NOT EXISTS stream_sequences(
+    userid BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL
+);
+
+ALTER TABLE stream_events
+ADD COLUMN seq BIGINT;
+
+-- ids were drawn in commit order, they carry on as sequence numbers so
+-- that clients resume where they were
+UPDATE stream_events SET seq = id;
+
+ALTER TABLE stream_events
+ALTER COLUMN seq SET NOT NULL;
+
+INSERT INTO stream_sequences (userid, seq)
+SELECT userid, MAX(seq) FROM stream_events GROUP BY userid;
+
+DROP INDEX IF EXISTS idx_stream_events_userid;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_stream_events_userid_seq ON stream_events(userid, seq);
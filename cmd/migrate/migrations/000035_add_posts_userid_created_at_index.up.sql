@@ -0,0 +1,3 @@
+-- Published posts of an author by time, read by the home feed for the
+-- accounts whose posts are not fanned out
+CREATE INDEX IF NOT EXISTS idx_posts_userid_created_at ON posts(userid, created_at DESC) WHERE status = 'published';
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+type entry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// Cache keeps values in memory for a fixed time. It is safe for use by
+// several goroutines.
+type Cache[V any] struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]entry[V]
+}
+
+// New returns a cache keeping values for ttl and at most max of them at
+// once.
+func New[V any](ttl time.Duration, max int) *Cache[V] {
+	return &Cache[V]{
+		ttl:     ttl,
+		max:     max,
+		entries: make(map[string]entry[V]),
+	}
+}
+
+// Get returns the value stored under key, if it did not expire.
+func (c *Cache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Set stores value under key. Expired values are dropped when the cache
+// is full, and the value is not stored when that freed no room.
+func (c *Cache[V]) Set(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.max {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.max {
+			return
+		}
+	}
+	c.entries[key] = entry[V]{value: value, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,79 @@
+package cursor
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalid = errors.New("invalid cursor")
+
+// Key is the position of the first or last row of a page in a keyset
+// paginated listing. Listings ordered by id alone leave Time unset. Back
+// asks for the rows before the key rather than after it. List and Sort
+// name the listing and its order, a key only positions pages of those.
+type Key struct {
+	List string    `json:"l"`
+	Sort string    `json:"s,omitempty"`
+	Time time.Time `json:"t,omitzero"`
+	ID   int64     `json:"id"`
+	Back bool      `json:"b,omitempty"`
+}
+
+// Codec turns keys into opaque strings that clients hand back to fetch
+// the next or previous page. The strings are signed so that clients can
+// not forge positions.
+type Codec struct {
+	secret []byte
+}
+
+// NewCodec signs cursors with a key derived from secret with HKDF, so
+// that secret can be shared with other uses, such as signing tokens,
+// without a cursor ever being a valid signature for them.
+func NewCodec(secret string) *Codec {
+	// HKDF only fails for keys longer than it can derive
+	key, _ := hkdf.Key(sha256.New, []byte(secret), nil, "cursor", sha256.Size)
+	return &Codec{secret: key}
+}
+
+func (c *Codec) Encode(key Key) string {
+	data, _ := json.Marshal(key)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(c.sign(payload))
+}
+
+// Decode returns the key of s, which must have been encoded for the
+// listing list in the order sort.
+func (c *Codec) Decode(s, list, sort string) (Key, error) {
+	var key Key
+	payload, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return key, ErrInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, c.sign(payload)) {
+		return key, ErrInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return key, ErrInvalid
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return key, ErrInvalid
+	}
+	if key.List != list || key.Sort != sort {
+		return Key{}, ErrInvalid
+	}
+	return key, nil
+}
+
+func (c *Codec) sign(payload string) []byte {
+	h := hmac.New(sha256.New, c.secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
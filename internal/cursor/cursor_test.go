@@ -0,0 +1,87 @@
+package cursor
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	c := NewCodec("secret")
+	tests := []struct {
+		name string
+		key  Key
+	}{
+		{"id only", Key{List: "drafts", ID: 42}},
+		{"time and id", Key{List: "followers", Time: time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC), ID: 7}},
+		{"sorted and back", Key{List: "feed", Sort: "asc", Time: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), ID: 1, Back: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Decode(c.Encode(tt.key), tt.key.List, tt.key.Sort)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !got.Time.Equal(tt.key.Time) {
+				t.Errorf("Time = %v, want %v", got.Time, tt.key.Time)
+			}
+			got.Time = tt.key.Time
+			if got != tt.key {
+				t.Errorf("Decode = %+v, want %+v", got, tt.key)
+			}
+		})
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	c := NewCodec("secret")
+	valid := c.Encode(Key{List: "feed", Sort: "desc", ID: 42})
+	payload, sig, _ := strings.Cut(valid, ".")
+	_, otherSig, _ := strings.Cut(c.Encode(Key{List: "feed", Sort: "desc", ID: 1}), ".")
+	// flip a character of the payload, keeping it valid base64
+	tampered := []byte(payload)
+	if tampered[0] == 'A' {
+		tampered[0] = 'B'
+	} else {
+		tampered[0] = 'A'
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		list   string
+		sort   string
+	}{
+		{"empty", "", "feed", "desc"},
+		{"no signature", payload, "feed", "desc"},
+		{"tampered payload", string(tampered) + "." + sig, "feed", "desc"},
+		{"signature of another payload", payload + "." + otherSig, "feed", "desc"},
+		{"bad signature encoding", payload + ".!!", "feed", "desc"},
+		{"bad payload encoding", "!!." + sig, "feed", "desc"},
+		{"wrong secret", NewCodec("other").Encode(Key{List: "feed", Sort: "desc", ID: 42}), "feed", "desc"},
+		{"other listing", valid, "followers", ""},
+		{"other sort", valid, "feed", "asc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.Decode(tt.cursor, tt.list, tt.sort); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Decode = %v, want %v", err, ErrInvalid)
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsSignedGarbage(t *testing.T) {
+	// a payload that is not a key, signed with the right key
+	c := NewCodec("secret")
+	payload := base64.RawURLEncoding.EncodeToString([]byte("not json"))
+	s := payload + "." + base64.RawURLEncoding.EncodeToString(c.sign(payload))
+
+	if _, err := c.Decode(s, "", ""); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Decode = %v, want %v", err, ErrInvalid)
+	}
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrBlocked   = errors.New("you can not interact with this user")
+	ErrSelfBlock = errors.New("you can not block or mute yourself")
+)
+
+// BlockEntry is a user in a block or mute list.
+type BlockEntry struct {
+	User
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// blocked is an SQL condition that holds when either of users a and b
+// blocked the other.
+func blocked(a, b string) string {
+	return `EXISTS (SELECT 1 FROM user_blocks
+		WHERE (userid = ` + a + ` AND blocked_id = ` + b + `)
+		OR (userid = ` + b + ` AND blocked_id = ` + a + `))`
+}
+
+// muted is an SQL condition that holds when user muted target.
+func muted(user, target string) string {
+	return `EXISTS (SELECT 1 FROM user_mutes WHERE userid = ` + user + ` AND muted_id = ` + target + `)`
+}
+
+type BlockStore struct {
+	db *sql.DB
+}
+
+// Block adds targetID to the block list of userID. Follows, follow
+// requests and timeline entries between the two users are removed in both
+// directions.
+func (b *BlockStore) Block(ctx context.Context, userID, targetID int64) error {
+	if userID == targetID {
+		return ErrSelfBlock
+	}
+	return withTx(b.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+		defer cancel()
+
+		if err := b.add(ctx, tx, "user_blocks", "blocked_id", userID, targetID); err != nil {
+			return err
+		}
+
+		query := `
+			DELETE FROM followers
+			WHERE (userid = $1 AND follower_id = $2) OR (userid = $2 AND follower_id = $1)
+		`
+		result, err := tx.ExecContext(ctx, query, userID, targetID)
+		if err != nil {
+			return err
+		}
+		unfollowed, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		query = `
+			DELETE FROM follow_requests
+			WHERE (userid = $1 AND requester_id = $2) OR (userid = $2 AND requester_id = $1)
+		`
+		if _, err := tx.ExecContext(ctx, query, userID, targetID); err != nil {
+			return err
+		}
+		if err := unfill(ctx, tx, userID, targetID); err != nil {
+			return err
+		}
+		if err := unfill(ctx, tx, targetID, userID); err != nil {
+			return err
+		}
+		if unfollowed == 0 {
+			return nil
+		}
+		if err := refan(ctx, tx, userID); err != nil {
+			return err
+		}
+		return refan(ctx, tx, targetID)
+	})
+}
+
+func (b *BlockStore) Unblock(ctx context.Context, userID, targetID int64) error {
+	return b.remove(ctx, "user_blocks", "blocked_id", userID, targetID)
+}
+
+// Mute hides the posts and notifications of targetID from userID.
+func (b *BlockStore) Mute(ctx context.Context, userID, targetID int64) error {
+	if userID == targetID {
+		return ErrSelfBlock
+	}
+	return withTx(b.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+		defer cancel()
+
+		return b.add(ctx, tx, "user_mutes", "muted_id", userID, targetID)
+	})
+}
+
+func (b *BlockStore) Unmute(ctx context.Context, userID, targetID int64) error {
+	return b.remove(ctx, "user_mutes", "muted_id", userID, targetID)
+}
+
+func (b *BlockStore) GetBlocked(ctx context.Context, userID int64, after cursor.Key, limit int) ([]BlockEntry, error) {
+	return b.list(ctx, "user_blocks", "blocked_id", userID, after, limit)
+}
+
+func (b *BlockStore) GetMuted(ctx context.Context, userID int64, after cursor.Key, limit int) ([]BlockEntry, error) {
+	return b.list(ctx, "user_mutes", "muted_id", userID, after, limit)
+}
+
+// add records targetID in one of the lists of userID, adding a user
+// twice is not an error.
+func (b *BlockStore) add(ctx context.Context, tx *sql.Tx, table, column string, userID, targetID int64) error {
+	query := `
+		INSERT INTO ` + table + ` (userid, ` + column + `)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+	_, err := tx.ExecContext(ctx, query, userID, targetID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (b *BlockStore) remove(ctx context.Context, table, column string, userID, targetID int64) error {
+	query := `
+		DELETE FROM ` + table + `
+		WHERE userid = $1 AND ` + column + ` = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := b.db.ExecContext(ctx, query, userID, targetID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (b *BlockStore) list(ctx context.Context, table, column string, userID int64,
+	after cursor.Key, limit int) ([]BlockEntry, error) {
+	query := `
+		SELECT u.id, u.name, COALESCE(u.display_name, ''), COALESCE(u.avatar_url, ''), l.created_at
+		FROM ` + table + ` l
+		JOIN users u ON u.id = l.` + column + `
+		WHERE l.userid = $1
+			AND ($3::bigint = 0 OR (l.created_at, u.id) < ($2::timestamptz, $3))
+		ORDER BY l.created_at DESC, u.id DESC
+		LIMIT $4
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := b.db.QueryContext(ctx, query, userID, after.Time, after.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []BlockEntry{}
+	for rows.Next() {
+		var e BlockEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.Name,
+			&e.DisplayName,
+			&e.AvatarURL,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
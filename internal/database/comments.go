@@ -3,6 +3,8 @@ package database
 import (
 	"context"
 	"database/sql"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/entities"
 )
 
 type Comment struct {
@@ -12,6 +14,8 @@ type Comment struct {
 	Postid    int64  `json:"postid"`
 	CreatedAt string `json:"created_at"`
 	User      User   `json:"user"`
+
+	Entities *entities.Entities `json:"entities,omitempty"`
 }
 
 type CommentStore struct {
@@ -22,7 +26,7 @@ func (c *CommentStore) GetComments(ctx context.Context, postID int64) ([]Comment
 	query := `
 		SELECT c.id, c.content, c.userid, c.postid, users.id, users.name FROM comments c
 		JOIN users on users.id = c.userid
-		WHERE postid = $1
+		WHERE postid = $1 AND users.is_active = true
 		ORDER BY created_at DESC
 	`
 	rows, err := c.db.QueryContext(ctx, query, postID)
@@ -44,21 +48,51 @@ func (c *CommentStore) GetComments(ctx context.Context, postID int64) ([]Comment
 }
 
 func (c *CommentStore) CreateComment(ctx context.Context, comment *Comment) error {
-	query := `
-		INSERT INTO comments (content, userid, postid)
-		VALUES($1, $2, $3) RETURNING id
-	`
-
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
 	defer cancel()
 
-	err := c.db.QueryRowContext(ctx, query, comment.Content, comment.Userid, comment.Postid).Scan(
-		&comment.ID,
-	)
+	return withTx(c.db, ctx, func(tx *sql.Tx) error {
+		// users that blocked each other can not comment on their posts
+		query := `
+			INSERT INTO comments (content, userid, postid)
+			SELECT $1::text, $2::bigint, p.id FROM posts p
+			WHERE p.id = $3 AND NOT ` + blocked("p.userid", "$2::bigint") + `
+			RETURNING id, created_at,
+			(SELECT userid FROM posts WHERE id = $3)
+		`
+		var authorID int64
+		err := tx.QueryRowContext(ctx, query, comment.Content, comment.Userid, comment.Postid).Scan(
+			&comment.ID,
+			&comment.CreatedAt,
+			&authorID,
+		)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrBlocked
+			}
+			return err
+		}
 
-	if err != nil {
-		return err
-	}
-	return nil
+		ents := entities.Parse(comment.Content)
+		err = recordMentions(ctx, tx, comment.Userid, comment.Postid, comment.ID, &ents)
+		if err != nil {
+			return err
+		}
+		comment.Entities = &ents
+
+		if authorID != comment.Userid {
+			err = emit(ctx, tx, EventComment, comment, []int64{authorID})
+			if err != nil {
+				return err
+			}
+		}
 
+		return notify(ctx, tx, &Notification{
+			UserID:    authorID,
+			ActorID:   comment.Userid,
+			Type:      NotifyComment,
+			PostID:    comment.Postid,
+			CommentID: comment.ID,
+		})
+	})
 }
@@ -3,9 +3,14 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 )
 
+// ErrLocked is returned by a background job that did nothing because
+// another instance is already running it.
+var ErrLocked = errors.New("job is running on another instance")
+
 func Mount(addr string, MaxConns, MaxIdleConn, MaxIdleTime int) (*sql.DB, error) {
 	db, err := sql.Open("postgres", addr)
 	if err != nil {
@@ -24,6 +29,11 @@ func Mount(addr string, MaxConns, MaxIdleConn, MaxIdleTime int) (*sql.DB, error)
 	return db, nil
 }
 
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}
+
 func withTx(db *sql.DB, ctx context.Context, fn func(*sql.Tx) error) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
@@ -37,3 +47,26 @@ func withTx(db *sql.DB, ctx context.Context, fn func(*sql.Tx) error) error {
 
 	return tx.Commit()
 }
+
+// Keys of the advisory locks taken by background jobs so that a job runs
+// on one instance at a time.
+const (
+	lockSuggestions int64 = iota + 1
+	lockPurge
+	lockRanking
+	lockTrending
+)
+
+// tryLock takes the advisory lock key for the duration of tx. It returns
+// ErrLocked when another transaction holds it.
+func tryLock(ctx context.Context, tx *sql.Tx, key int64) error {
+	var locked bool
+	err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, key).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return ErrLocked
+	}
+	return nil
+}
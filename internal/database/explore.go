@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+)
+
+// Explore returns a page of the recent posts of every public account, as
+// feed entries placed at the creation of their post. The top and relevant
+// orders both rank posts by engagement and age, there are no follows to
+// weigh here. Posts of inactive and suspended users, and of users blocking,
+// blocked or muted by the viewer, are left out; viewerID is 0 for
+// anonymous viewers.
+func (p *PostStore) Explore(ctx context.Context, viewerID int64, fq *FilteringQuery) ([]Feed, error) {
+	w := fq.Weights
+	order := "p.created_at " + fq.Sort + ", p.id " + fq.Sort
+	window := 0.0
+	if fq.Sort == SortTop || fq.Sort == SortRelevant {
+		order = "score DESC, p.id DESC"
+		window = RankWindow.Seconds()
+	}
+
+	query := `
+		WITH scored AS (
+			SELECT p.id,
+			COALESCE(s.comments, p.comment_count) AS comments,
+			COALESCE(s.reactions, 0) AS reactions,
+			COALESCE(s.reposts, p.repost_count) AS reposts,
+			COALESCE(s.engagement,
+				$7::float8 * p.comment_count + $8::float8 * p.repost_count) AS engagement,
+			EXTRACT(EPOCH FROM now() - p.created_at) / 3600 AS age
+			FROM posts p
+			LEFT JOIN post_scores s ON s.postid = p.id
+		)
+		SELECT p.id, p.userid, p.title, p.content, p.format, p.content_html, p.tags,
+		p.comment_count, p.repost_count, u.name, p.created_at, p.created_at,
+		(1 + k.engagement) * power(0.5, k.age / $9::float8) AS score,
+		k.engagement, k.comments, k.reactions, k.reposts, k.age,
+		power(0.5, k.age / $9::float8)
+		FROM posts p
+		JOIN scored k ON k.id = p.id
+		JOIN users u ON u.id = p.userid
+		WHERE p.status = 'published'
+		AND u.is_active = true AND NOT u.is_private AND u.suspended_at IS NULL
+		AND ($2 = '' OR p.search @@ to_tsquery(p.language, $2))
+		AND (p.tags @> $3 OR $3 = '{}')
+		AND ($6::float8 = 0 OR p.created_at > now() - make_interval(secs => $6))
+		AND NOT ` + blocked("p.userid", "$1") + `
+		AND NOT ` + muted("$1", "p.userid") + `
+		ORDER BY ` + order + `
+		LIMIT $4 OFFSET $5
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := p.db.QueryContext(
+		ctx, query, viewerID, tsquery(fq.Search), pq.Array(fq.Tags), fq.Limit, fq.Offset,
+		window, w.Comments, w.Reposts, w.HalfLife.Hours(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	output := []Feed{}
+	for rows.Next() {
+		var feed Feed
+		var rank Ranking
+		err := rows.Scan(
+			&feed.Post.ID,
+			&feed.Post.UserID,
+			&feed.Post.Title,
+			&feed.Post.Content,
+			&feed.Post.Format,
+			&feed.Post.ContentHTML,
+			pq.Array(&feed.Post.Tags),
+			&feed.CommentCount,
+			&feed.Post.RepostCount,
+			&feed.Post.User.Name,
+			&feed.Post.CreatedAt,
+			&feed.At,
+			&rank.Score,
+			&rank.Engagement,
+			&rank.Comments,
+			&rank.Reactions,
+			&rank.Reposts,
+			&rank.AgeHours,
+			&rank.Decay,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if fq.Explain && window != 0 {
+			feed.Ranking = &rank
+		}
+		feed.Post.User.ID = feed.Post.UserID
+		output = append(output, feed)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return output, loadAttached(ctx, p.db, feedPosts(output))
+}
@@ -0,0 +1,372 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
+	"github.com/lib/pq"
+)
+
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+var ErrExportInProgress = errors.New("an export is already in progress")
+
+// DataExport is a request of a user for a copy of their data. Once ready
+// the archive at Path can be downloaded with the token mailed to them
+// until ExpiresAt.
+type DataExport struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"userid"`
+	Status    string     `json:"status"`
+	Path      string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// UserData is everything held about a user, as exported to them.
+type UserData struct {
+	Profile       User           `json:"profile"`
+	Posts         []Post         `json:"posts"`
+	Revisions     []PostRevision `json:"revisions"`
+	Comments      []Comment      `json:"comments"`
+	Followers     []FollowEntry  `json:"followers"`
+	Following     []FollowEntry  `json:"following"`
+	Reactions     []Reaction     `json:"reactions"`
+	Notifications []Notification `json:"notifications"`
+}
+
+type ExportStore struct {
+	db *sql.DB
+}
+
+func (e *ExportStore) Request(ctx context.Context, userID int64) (*DataExport, error) {
+	query := `
+		INSERT INTO data_exports (userid)
+		VALUES ($1)
+		RETURNING id, status, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	export := &DataExport{UserID: userID}
+	err := e.db.QueryRowContext(ctx, query, userID).Scan(
+		&export.ID,
+		&export.Status,
+		&export.CreatedAt,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrExportInProgress
+		}
+		return nil, err
+	}
+	return export, nil
+}
+
+// Claim marks up to limit pending exports as running and returns them.
+// Exports claimed by another instance are skipped.
+func (e *ExportStore) Claim(ctx context.Context, limit int) ([]DataExport, error) {
+	query := `
+		UPDATE data_exports
+		SET status = $1
+		WHERE id IN (
+			SELECT id FROM data_exports
+			WHERE status = $2
+			ORDER BY created_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, userid, status, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := e.db.QueryContext(ctx, query, ExportRunning, ExportPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []DataExport
+	for rows.Next() {
+		var export DataExport
+		err := rows.Scan(
+			&export.ID,
+			&export.UserID,
+			&export.Status,
+			&export.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+// Complete records where the archive of a running export was stored and
+// the hash of the token it can be downloaded with.
+func (e *ExportStore) Complete(ctx context.Context, export *DataExport, tokenHash string, expiry time.Time) error {
+	query := `
+		UPDATE data_exports
+		SET status = $2, path = $3, token = $4, expires_at = $5
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	_, err := e.db.ExecContext(ctx, query, export.ID, ExportReady, export.Path, tokenHash, expiry)
+	if err != nil {
+		return err
+	}
+	export.Status = ExportReady
+	export.ExpiresAt = &expiry
+	return nil
+}
+
+func (e *ExportStore) Fail(ctx context.Context, id int64) error {
+	query := `
+		UPDATE data_exports
+		SET status = $2
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	_, err := e.db.ExecContext(ctx, query, id, ExportFailed)
+	return err
+}
+
+// GetByToken returns the ready export that token unlocks, ErrNotFound
+// when there is none or it expired.
+func (e *ExportStore) GetByToken(ctx context.Context, token string, now time.Time) (*DataExport, error) {
+	query := `
+		SELECT id, userid, status, path, created_at, expires_at
+		FROM data_exports
+		WHERE token = $1 AND status = $2 AND expires_at > $3
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	hashtoken := sha256.Sum256([]byte(token))
+	hash := hex.EncodeToString(hashtoken[:])
+
+	var export DataExport
+	err := e.db.QueryRowContext(ctx, query, hash, ExportReady, now).Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.Path,
+		&export.CreatedAt,
+		&export.ExpiresAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &export, nil
+}
+
+// Expire deletes the exports that expired before now, the failed ones and
+// the ones left running for a day by an instance that stopped, and returns
+// them so that their archives can be removed.
+func (e *ExportStore) Expire(ctx context.Context, now time.Time) ([]DataExport, error) {
+	query := `
+		DELETE FROM data_exports
+		WHERE expires_at < $1 OR status = $2
+		OR (status = $3 AND created_at < $1 - interval '1 day')
+		RETURNING id, userid, status, COALESCE(path, '')
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := e.db.QueryContext(ctx, query, now, ExportFailed, ExportRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []DataExport
+	for rows.Next() {
+		var export DataExport
+		err := rows.Scan(
+			&export.ID,
+			&export.UserID,
+			&export.Status,
+			&export.Path,
+		)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+// UserData collects everything held about userID. Posts are exported at
+// their current version, along with the revisions kept by their edits.
+func (e *ExportStore) UserData(ctx context.Context, userID int64) (*UserData, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	data := &UserData{
+		Posts:         []Post{},
+		Revisions:     []PostRevision{},
+		Comments:      []Comment{},
+		Reactions:     []Reaction{},
+		Notifications: []Notification{},
+	}
+
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE id = $1
+	`
+	if err := scanUser(e.db.QueryRowContext(ctx, query, userID), &data.Profile); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT id, title, content, format, content_html, tags, version, status, publish_at, created_at, updated_at
+		FROM posts
+		WHERE userid = $1
+		ORDER BY created_at
+	`
+	err := collect(ctx, e.db, query, userID, func(rows *sql.Rows) error {
+		p := Post{UserID: userID}
+		err := rows.Scan(&p.ID, &p.Title, &p.Content, &p.Format, &p.ContentHTML, pq.Array(&p.Tags), &p.Version,
+			&p.Status, &p.PublishAt, &p.CreatedAt, &p.UpdatedAt)
+		data.Posts = append(data.Posts, p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT r.postid, r.version, r.title, r.content, r.format, r.tags, r.edited_at
+		FROM post_revisions r
+		JOIN posts p ON p.id = r.postid
+		WHERE p.userid = $1
+		ORDER BY r.postid, r.version
+	`
+	err = collect(ctx, e.db, query, userID, func(rows *sql.Rows) error {
+		var r PostRevision
+		err := rows.Scan(&r.PostID, &r.Version, &r.Title, &r.Content, &r.Format, pq.Array(&r.Tags), &r.EditedAt)
+		data.Revisions = append(data.Revisions, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT id, postid, content, created_at
+		FROM comments
+		WHERE userid = $1
+		ORDER BY created_at
+	`
+	err = collect(ctx, e.db, query, userID, func(rows *sql.Rows) error {
+		c := Comment{Userid: userID}
+		err := rows.Scan(&c.ID, &c.Postid, &c.Content, &c.CreatedAt)
+		data.Comments = append(data.Comments, c)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := &UserStore{db: e.db}
+	if data.Followers, err = users.allFollows(ctx, users.GetFollowers, userID); err != nil {
+		return nil, err
+	}
+	if data.Following, err = users.allFollows(ctx, users.GetFollowing, userID); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT postid, kind, created_at
+		FROM reactions
+		WHERE userid = $1
+		ORDER BY created_at
+	`
+	err = collect(ctx, e.db, query, userID, func(rows *sql.Rows) error {
+		r := Reaction{UserID: userID}
+		err := rows.Scan(&r.PostID, &r.Kind, &r.CreatedAt)
+		data.Reactions = append(data.Reactions, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT id, actor_id, type, COALESCE(postid, 0), COALESCE(commentid, 0),
+		read_at IS NOT NULL, created_at
+		FROM notifications
+		WHERE userid = $1
+		ORDER BY id
+	`
+	err = collect(ctx, e.db, query, userID, func(rows *sql.Rows) error {
+		n := Notification{UserID: userID}
+		err := rows.Scan(&n.ID, &n.ActorID, &n.Type, &n.PostID, &n.CommentID, &n.Read, &n.CreatedAt)
+		data.Notifications = append(data.Notifications, n)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// allFollows pages through a followers or following listing to the end.
+func (u *UserStore) allFollows(ctx context.Context,
+	list func(context.Context, int64, int64, cursor.Key, int) ([]FollowEntry, error),
+	userID int64) ([]FollowEntry, error) {
+	const pageSize = 500
+
+	var after cursor.Key
+	output := []FollowEntry{}
+	for {
+		page, err := list(ctx, userID, userID, after, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, page...)
+		if len(page) < pageSize {
+			return output, nil
+		}
+		last := page[len(page)-1]
+		after = cursor.Key{Time: last.FollowedAt, ID: last.ID}
+	}
+}
+
+// collect runs query with userID and calls scan for every row.
+func collect(ctx context.Context, q queryer, query string, userID int64, scan func(*sql.Rows) error) error {
+	rows, err := q.QueryContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -4,14 +4,22 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
 )
 
 type FilteringQuery struct {
 	Search string   `json:"search" validate:"max=100"`
 	Tags   []string `json:"tags"`
-	Limit  int      `json:"limit" validate:"gte=1, lte=20"`
+	Limit  int      `json:"limit" validate:"gte=1,lte=20"`
 	Offset int      `json:"offset" validate:"gte=0"`
-	Sort   string   `json:"sort" validate:"oneof=asc desc"`
+	Sort   string   `json:"sort" validate:"oneof=asc desc top relevant"`
+	// Explain asks ranked feeds to tell how each entry was scored
+	Explain bool `json:"explain"`
+	// Cursor, when set, replaces Offset to position the page
+	Cursor *cursor.Key `json:"-"`
+	// Weights rank the entries of the top and relevant orders
+	Weights RankWeights `json:"-"`
 }
 
 func (fq *FilteringQuery) Parse(r *http.Request) error {
@@ -21,6 +29,7 @@ func (fq *FilteringQuery) Parse(r *http.Request) error {
 	search := query.Get("search")
 	tags := query.Get("tags")
 	sort := query.Get("sort")
+	explain := query.Get("explain")
 	var err error
 	if limit != "" {
 		fq.Limit, err = strconv.Atoi(limit)
@@ -48,6 +57,82 @@ func (fq *FilteringQuery) Parse(r *http.Request) error {
 		fq.Tags = strings.Split(tags, ",")
 	}
 
+	if explain != "" {
+		fq.Explain, err = strconv.ParseBool(explain)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// UserSearchQuery selects a page of the users matching Q.
+type UserSearchQuery struct {
+	Q      string `json:"q" validate:"required,max=100"`
+	Limit  int    `json:"limit" validate:"gte=1,lte=50"`
+	Offset int    `json:"offset" validate:"gte=0"`
+}
+
+func (uq *UserSearchQuery) Parse(r *http.Request) error {
+	query := r.URL.Query()
+	limit := query.Get("limit")
+	offset := query.Get("offset")
+	var err error
+	if limit != "" {
+		uq.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return err
+		}
+	}
+
+	if offset != "" {
+		uq.Offset, err = strconv.Atoi(offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	uq.Q = strings.TrimSpace(query.Get("q"))
+
 	return nil
+}
+
+// PostSearchQuery selects a page of the posts matching Q. Lang is the text
+// search configuration Q is stemmed with.
+type PostSearchQuery struct {
+	Q      string `json:"q" validate:"required,max=200"`
+	Lang   string `json:"lang" validate:"oneof=simple english french german spanish italian portuguese dutch russian"`
+	Limit  int    `json:"limit" validate:"gte=1,lte=50"`
+	Offset int    `json:"offset" validate:"gte=0"`
+}
+
+func (sq *PostSearchQuery) Parse(r *http.Request) error {
+	query := r.URL.Query()
+	limit := query.Get("limit")
+	offset := query.Get("offset")
+	lang := query.Get("lang")
+	var err error
+	if limit != "" {
+		sq.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return err
+		}
+	}
 
+	if offset != "" {
+		sq.Offset, err = strconv.Atoi(offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	if lang != "" {
+		sq.Lang = lang
+	}
+
+	sq.Q = strings.TrimSpace(query.Get("q"))
+
+	return nil
 }
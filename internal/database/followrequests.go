@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
+)
+
+// GetFollowRequests lists the users waiting for userID to approve them,
+// most recent first. FollowedAt is the time of the request.
+func (u *UserStore) GetFollowRequests(ctx context.Context, userID int64,
+	after cursor.Key, limit int) ([]FollowEntry, error) {
+	query := `
+		SELECT u.id, u.name, COALESCE(u.display_name, ''), COALESCE(u.bio, ''),
+			COALESCE(u.avatar_url, ''), fr.created_at
+		FROM follow_requests fr
+		JOIN users u ON u.id = fr.requester_id
+		WHERE fr.userid = $1 AND u.is_active = true
+			AND ($3::bigint = 0 OR (fr.created_at, u.id) < ($2::timestamptz, $3))
+		ORDER BY fr.created_at DESC, u.id DESC
+		LIMIT $4
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := u.db.QueryContext(ctx, query, userID, after.Time, after.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []FollowEntry{}
+	for rows.Next() {
+		var e FollowEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.Name,
+			&e.DisplayName,
+			&e.Bio,
+			&e.AvatarURL,
+			&e.FollowedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// ApproveFollowRequest turns the pending request of requesterID into a
+// follow of userID.
+func (u *UserStore) ApproveFollowRequest(ctx context.Context, userID, requesterID int64) error {
+	return withTx(u.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			WITH request AS (
+				DELETE FROM follow_requests
+				WHERE userid = $1 AND requester_id = $2
+				RETURNING userid, requester_id
+			)
+			INSERT INTO followers (userid, follower_id)
+			SELECT userid, requester_id FROM request
+			ON CONFLICT DO NOTHING
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, userID, requesterID)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrNotFound
+		}
+		if err := backfill(ctx, tx, requesterID, userID); err != nil {
+			return err
+		}
+
+		return notify(ctx, tx, &Notification{
+			UserID:  requesterID,
+			ActorID: userID,
+			Type:    NotifyFollowAccept,
+		})
+	})
+}
+
+func (u *UserStore) RejectFollowRequest(ctx context.Context, userID, requesterID int64) error {
+	query := `
+		DELETE FROM follow_requests
+		WHERE userid = $1 AND requester_id = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := u.db.ExecContext(ctx, query, userID, requesterID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
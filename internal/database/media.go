@@ -0,0 +1,428 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Images are resized into variants once uploaded, videos are ready as
+// they are.
+const (
+	MediaPending = "pending"
+	MediaReady   = "ready"
+	MediaFailed  = "failed"
+)
+
+// MediaClaimTimeout is how long processing a medium may take before
+// another run takes it over.
+const MediaClaimTimeout = time.Minute * 10
+
+var ErrMediaNotFound = errors.New("media not found or already attached")
+
+const mediaColumns = `m.id, m.userid, m.key, m.kind, m.mime, m.size, m.status,
+	COALESCE(m.width, 0), COALESCE(m.height, 0), COALESCE(m.blurhash, ''), m.created_at`
+
+// Media is an image or video uploaded by a user, its content is kept in a
+// blob store under Key. Once attached to a post it is seen by those who
+// see the post, though only the uploader gets the original of an image,
+// others get its variants.
+type Media struct {
+	ID       int64  `json:"id"`
+	UserID   int64  `json:"userid"`
+	Key      string `json:"-"`
+	Kind     string `json:"kind"`
+	MIME     string `json:"mime"`
+	Size     int64  `json:"size"`
+	Status   string `json:"status"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Blurhash string `json:"blurhash,omitempty"`
+	// URL is where the original is served, to those allowed to get it
+	URL       string         `json:"url,omitempty"`
+	Variants  []MediaVariant `json:"variants"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// MediaVariant is a resized copy of an image, kept under Key.
+type MediaVariant struct {
+	Name   string `json:"name"`
+	Key    string `json:"-"`
+	MIME   string `json:"mime"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int64  `json:"size"`
+	URL    string `json:"url"`
+}
+
+type MediaStore struct {
+	db *sql.DB
+}
+
+func (m *MediaStore) Create(ctx context.Context, media *Media) error {
+	query := `
+		INSERT INTO media (userid, key, kind, mime, size, status)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	media.Variants = []MediaVariant{}
+	return m.db.QueryRowContext(ctx, query,
+		media.UserID,
+		media.Key,
+		media.Kind,
+		media.MIME,
+		media.Size,
+		media.Status,
+	).Scan(&media.ID, &media.CreatedAt)
+}
+
+// visibleMedia is an SQL condition that holds when viewer uploaded the
+// medium m, or it is attached to a published post viewer may see.
+func visibleMedia(viewer string) string {
+	return `(m.userid = ` + viewer + ` OR EXISTS (
+		SELECT 1 FROM post_media pm
+		JOIN posts p ON p.id = pm.postid
+		WHERE pm.mediaid = m.id AND p.status = 'published'
+		AND ` + canSee("p.userid", viewer) + `
+		AND NOT ` + blocked("p.userid", viewer) + `
+	))`
+}
+
+// GetByID returns the media with its variants if viewerID uploaded it, or
+// if it is attached to a published post viewerID is allowed to see.
+func (m *MediaStore) GetByID(ctx context.Context, id, viewerID int64) (*Media, error) {
+	query := `
+		SELECT ` + mediaColumns + `
+		FROM media m
+		WHERE m.id = $1 AND ` + visibleMedia("$2") + `
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	media, err := queryMedia(ctx, m.db, query, id, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(media) == 0 {
+		return nil, ErrNotFound
+	}
+	return &media[0], nil
+}
+
+// GetVariant returns the variant name of the media id, if viewerID may
+// see the media.
+func (m *MediaStore) GetVariant(ctx context.Context, id int64, name string, viewerID int64) (*MediaVariant, error) {
+	query := `
+		SELECT v.name, v.key, v.mime, v.width, v.height, v.size
+		FROM media m
+		JOIN media_variants v ON v.mediaid = m.id
+		WHERE m.id = $1 AND v.name = $2 AND ` + visibleMedia("$3") + `
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var v MediaVariant
+	err := m.db.QueryRowContext(ctx, query, id, name, viewerID).Scan(
+		&v.Name,
+		&v.Key,
+		&v.MIME,
+		&v.Width,
+		&v.Height,
+		&v.Size,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Claim returns the oldest pending medium, taking it over from any run
+// that claimed it more than MediaClaimTimeout ago. It returns ErrNotFound
+// when nothing is left to process.
+func (m *MediaStore) Claim(ctx context.Context) (*Media, error) {
+	query := `
+		UPDATE media m
+		SET claimed_at = now()
+		WHERE m.id = (
+			SELECT id FROM media
+			WHERE status = 'pending'
+			AND (claimed_at IS NULL OR claimed_at < now() - make_interval(secs => $1))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + mediaColumns + `
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, MediaClaimTimeout.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	media, err := scanMedia(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(media) == 0 {
+		return nil, ErrNotFound
+	}
+	return &media[0], nil
+}
+
+// Complete records the variants of a processed medium and marks it ready.
+func (m *MediaStore) Complete(ctx context.Context, media *Media) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(m.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO media_variants (mediaid, name, key, mime, width, height, size)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (mediaid, name) DO UPDATE
+			SET key = EXCLUDED.key, mime = EXCLUDED.mime, width = EXCLUDED.width,
+			height = EXCLUDED.height, size = EXCLUDED.size
+		`
+		for _, v := range media.Variants {
+			_, err := tx.ExecContext(ctx, query, media.ID, v.Name, v.Key, v.MIME, v.Width, v.Height, v.Size)
+			if err != nil {
+				return err
+			}
+		}
+
+		query = `
+			UPDATE media
+			SET status = 'ready', width = $2, height = $3, blurhash = $4, claimed_at = NULL
+			WHERE id = $1
+		`
+		_, err := tx.ExecContext(ctx, query, media.ID, media.Width, media.Height, media.Blurhash)
+		return err
+	})
+}
+
+// Fail marks a medium that could not be processed.
+func (m *MediaStore) Fail(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, `UPDATE media SET status = 'failed', claimed_at = NULL WHERE id = $1`, id)
+	return err
+}
+
+// PurgeOrphans deletes the media uploaded before the given time that were
+// never attached to a post, or whose post or uploader was deleted, and
+// returns the keys of their originals and variants for the blobs to be
+// removed.
+func (m *MediaStore) PurgeOrphans(ctx context.Context, before time.Time) ([]string, error) {
+	// the select sees the variants as they were before the cascade
+	query := `
+		WITH purged AS (
+			DELETE FROM media m
+			WHERE m.created_at < $1
+			AND NOT EXISTS (SELECT 1 FROM post_media WHERE mediaid = m.id)
+			RETURNING m.id, m.key
+		)
+		SELECT key FROM purged
+		UNION ALL
+		SELECT v.key FROM media_variants v JOIN purged p ON p.id = v.mediaid
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// attachMedia attaches post.Media, of which only the IDs are read, in
+// order as part of tx and fills them in. It returns ErrMediaNotFound
+// unless every medium was uploaded by the author and is not attached yet.
+func attachMedia(ctx context.Context, tx *sql.Tx, post *Post) error {
+	if len(post.Media) == 0 {
+		post.Media = []Media{}
+		return nil
+	}
+	ids := make([]int64, len(post.Media))
+	for i, m := range post.Media {
+		ids[i] = m.ID
+	}
+	query := `
+		WITH attached AS (
+			INSERT INTO post_media (postid, mediaid, position)
+			SELECT $1::bigint, m.id, a.position
+			FROM unnest($2::bigint[]) WITH ORDINALITY AS a(id, position)
+			JOIN media m ON m.id = a.id AND m.userid = $3
+			ON CONFLICT DO NOTHING
+			RETURNING mediaid, position
+		)
+		SELECT ` + mediaColumns + `
+		FROM attached a
+		JOIN media m ON m.id = a.mediaid
+		ORDER BY a.position
+	`
+	media, err := queryMedia(ctx, tx, query, post.ID, pq.Array(ids), post.UserID)
+	if err != nil {
+		return err
+	}
+	if len(media) != len(ids) {
+		return ErrMediaNotFound
+	}
+	post.Media = media
+	return nil
+}
+
+// getAttachments returns the media attached to postID, in order.
+func getAttachments(ctx context.Context, q queryer, postID int64) ([]Media, error) {
+	attachments, err := attachmentsOf(ctx, q, []int64{postID})
+	if err != nil {
+		return nil, err
+	}
+	return append([]Media{}, attachments[postID]...), nil
+}
+
+// attachmentsOf returns, per post, the media attached to postIDs, in
+// order.
+func attachmentsOf(ctx context.Context, q queryer, postIDs []int64) (map[int64][]Media, error) {
+	query := `
+		SELECT postid, mediaid
+		FROM post_media
+		WHERE postid = ANY($1)
+		ORDER BY postid, position
+	`
+	rows, err := q.QueryContext(ctx, query, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type attachment struct{ postID, mediaID int64 }
+	var attached []attachment
+	var ids []int64
+	for rows.Next() {
+		var a attachment
+		if err := rows.Scan(&a.postID, &a.mediaID); err != nil {
+			return nil, err
+		}
+		attached = append(attached, a)
+		ids = append(ids, a.mediaID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	output := make(map[int64][]Media)
+	if len(ids) == 0 {
+		return output, nil
+	}
+	query = `
+		SELECT ` + mediaColumns + `
+		FROM media m
+		WHERE m.id = ANY($1)
+	`
+	media, err := queryMedia(ctx, q, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]Media, len(media))
+	for _, m := range media {
+		byID[m.ID] = m
+	}
+	for _, a := range attached {
+		output[a.postID] = append(output[a.postID], byID[a.mediaID])
+	}
+	return output, nil
+}
+
+// queryMedia runs a query selecting mediaColumns and loads the variants
+// of the media.
+func queryMedia(ctx context.Context, q queryer, query string, args ...any) ([]Media, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	media, err := scanMedia(rows)
+	if err != nil || len(media) == 0 {
+		return media, err
+	}
+
+	ids := make([]int64, len(media))
+	byID := make(map[int64]*Media, len(media))
+	for i := range media {
+		ids[i] = media[i].ID
+		byID[media[i].ID] = &media[i]
+	}
+	query = `
+		SELECT mediaid, name, key, mime, width, height, size
+		FROM media_variants
+		WHERE mediaid = ANY($1)
+		ORDER BY mediaid, width
+	`
+	rows, err = q.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var v MediaVariant
+		if err := rows.Scan(&id, &v.Name, &v.Key, &v.MIME, &v.Width, &v.Height, &v.Size); err != nil {
+			return nil, err
+		}
+		byID[id].Variants = append(byID[id].Variants, v)
+	}
+	return media, rows.Err()
+}
+
+func scanMedia(rows *sql.Rows) ([]Media, error) {
+	defer rows.Close()
+
+	media := []Media{}
+	for rows.Next() {
+		var m Media
+		var userID sql.NullInt64
+		err := rows.Scan(
+			&m.ID,
+			&userID,
+			&m.Key,
+			&m.Kind,
+			&m.MIME,
+			&m.Size,
+			&m.Status,
+			&m.Width,
+			&m.Height,
+			&m.Blurhash,
+			&m.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		m.UserID = userID.Int64
+		m.Variants = []MediaVariant{}
+		media = append(media, m)
+	}
+
+	return media, rows.Err()
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/entities"
+	"github.com/lib/pq"
+)
+
+type Mention struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"userid"`
+	AuthorID  int64  `json:"author_id"`
+	PostID    int64  `json:"postid"`
+	CommentID int64  `json:"commentid,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// recordMentions records, as part of tx, a mention of every active user
+// named in ents by the author of a post, or of a comment when commentID is
+// not zero, notifies them and resolves their mentions in ents. The author,
+// users that can not see the post of a private account and users blocking
+// or blocked by the author are neither recorded nor notified, their
+// mentions are dropped from ents.
+func recordMentions(ctx context.Context, tx *sql.Tx, authorID, postID, commentID int64,
+	ents *entities.Entities) error {
+	usernames := ents.Usernames()
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	query := `
+		WITH mentioned AS (
+			SELECT id, name,
+			id <> $1::bigint AND ` + canSee("(SELECT userid FROM posts WHERE id = $2::bigint)", "users.id") + `
+			AND NOT ` + blocked("users.id", "$1::bigint") + ` AS notified
+			FROM users
+			WHERE name = ANY($4) AND is_active = true
+		), recorded AS (
+			INSERT INTO mentions (userid, author_id, postid, commentid)
+			SELECT id, $1::bigint, $2::bigint, NULLIF($3::bigint, 0)
+			FROM mentioned
+			WHERE notified
+		)
+		SELECT id, name FROM mentioned
+		WHERE notified
+	`
+	rows, err := tx.QueryContext(ctx, query, authorID, postID, commentID, pq.Array(usernames))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int64, len(usernames))
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		ids[name] = id
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		err := notify(ctx, tx, &Notification{
+			UserID:    id,
+			ActorID:   authorID,
+			Type:      NotifyMention,
+			PostID:    postID,
+			CommentID: commentID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	ents.Resolve(ids)
+	return nil
+}
@@ -0,0 +1,498 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// MaxConversationMembers caps the size of group conversations.
+const MaxConversationMembers = 10
+
+var (
+	ErrDMNotAllowed     = errors.New("user does not accept direct messages from you")
+	ErrTooManyMembers   = errors.New("too many conversation members")
+	ErrNoSuchRecipients = errors.New("conversation members not found")
+)
+
+type Conversation struct {
+	ID          int64    `json:"id"`
+	CreatedAt   string   `json:"created_at"`
+	Members     []User   `json:"members"`
+	LastMessage *Message `json:"last_message,omitempty"`
+	Unread      int      `json:"unread"`
+}
+
+type Message struct {
+	ID             int64  `json:"id"`
+	ConversationID int64  `json:"conversation_id"`
+	SenderID       int64  `json:"sender_id"`
+	Content        string `json:"content"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// ReadReceipt tells the members of a conversation how far a user read.
+type ReadReceipt struct {
+	ConversationID int64 `json:"conversation_id"`
+	UserID         int64 `json:"userid"`
+	MessageID      int64 `json:"message_id"`
+}
+
+// Typing tells the members of a conversation that a user is typing.
+type Typing struct {
+	ConversationID int64 `json:"conversation_id"`
+	UserID         int64 `json:"userid"`
+}
+
+// mutualFollow is an SQL condition that holds when users a and b follow
+// each other.
+func mutualFollow(a, b string) string {
+	return `(EXISTS (SELECT 1 FROM followers WHERE userid = ` + a + ` AND follower_id = ` + b + `)
+		AND EXISTS (SELECT 1 FROM followers WHERE userid = ` + b + ` AND follower_id = ` + a + `))`
+}
+
+// dmAllowed is an SQL condition that holds when sender may message
+// recipient: neither blocked the other, and either the recipient accepts
+// messages from everyone or they follow each other.
+func dmAllowed(sender, recipient string) string {
+	return `(NOT ` + blocked(sender, recipient) + ` AND
+		(NOT (SELECT dm_mutuals_only FROM users WHERE id = ` + recipient + `)
+		OR ` + mutualFollow(sender, recipient) + `))`
+}
+
+type ConversationStore struct {
+	db *sql.DB
+}
+
+// CreateConversation starts a conversation between the creator and
+// memberIDs. A one to one conversation that already exists is returned
+// instead of starting a second one.
+func (c *ConversationStore) CreateConversation(ctx context.Context, creatorID int64,
+	memberIDs []int64) (*Conversation, error) {
+	members := []int64{creatorID}
+	seen := map[int64]bool{creatorID: true}
+	for _, id := range memberIDs {
+		if !seen[id] {
+			seen[id] = true
+			members = append(members, id)
+		}
+	}
+	if len(members) < 2 {
+		return nil, ErrNoSuchRecipients
+	}
+	if len(members) > MaxConversationMembers {
+		return nil, ErrTooManyMembers
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var convID int64
+	err := withTx(c.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE id <> $2 AND NOT ` + dmAllowed("$2", "u.id") + `)
+			FROM users u
+			WHERE id = ANY($1) AND is_active = true
+		`
+		var found, refused int
+		err := tx.QueryRowContext(ctx, query, pq.Array(members), creatorID).Scan(&found, &refused)
+		if err != nil {
+			return err
+		}
+		if found != len(members) {
+			return ErrNoSuchRecipients
+		}
+		if refused > 0 {
+			return ErrDMNotAllowed
+		}
+
+		if len(members) == 2 {
+			query = `
+				SELECT conversation_id
+				FROM conversation_members
+				WHERE conversation_id IN (
+					SELECT conversation_id FROM conversation_members WHERE userid = $2
+				)
+				GROUP BY conversation_id
+				HAVING COUNT(*) = 2 AND bool_and(userid = ANY($1))
+				LIMIT 1
+			`
+			err = tx.QueryRowContext(ctx, query, pq.Array(members), creatorID).Scan(&convID)
+			if err == nil {
+				return nil
+			}
+			if err != sql.ErrNoRows {
+				return err
+			}
+		}
+
+		query = `
+			INSERT INTO conversations (created_by)
+			VALUES ($1) RETURNING id
+		`
+		if err = tx.QueryRowContext(ctx, query, creatorID).Scan(&convID); err != nil {
+			return err
+		}
+		query = `
+			INSERT INTO conversation_members (conversation_id, userid)
+			SELECT $1::bigint, m FROM unnest($2::bigint[]) AS m
+		`
+		_, err = tx.ExecContext(ctx, query, convID, pq.Array(members))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetConversation(ctx, convID, creatorID)
+}
+
+func (c *ConversationStore) scanConversations(ctx context.Context, rows *sql.Rows) ([]Conversation, error) {
+	defer rows.Close()
+
+	var output []Conversation
+	var ids []int64
+	for rows.Next() {
+		var conv Conversation
+		var lastID, senderID sql.NullInt64
+		var content, sentAt sql.NullString
+		err := rows.Scan(
+			&conv.ID,
+			&conv.CreatedAt,
+			&conv.Unread,
+			&lastID,
+			&senderID,
+			&content,
+			&sentAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if lastID.Valid {
+			conv.LastMessage = &Message{
+				ID:             lastID.Int64,
+				ConversationID: conv.ID,
+				SenderID:       senderID.Int64,
+				Content:        content.String,
+				CreatedAt:      sentAt.String,
+			}
+		}
+		output = append(output, conv)
+		ids = append(ids, conv.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return output, nil
+	}
+
+	query := `
+		SELECT cm.conversation_id, u.id, u.name
+		FROM conversation_members cm
+		JOIN users u ON u.id = cm.userid
+		WHERE cm.conversation_id = ANY($1)
+		ORDER BY cm.joined_at
+	`
+	members, err := c.db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer members.Close()
+
+	byConv := make(map[int64][]User)
+	for members.Next() {
+		var convID int64
+		var user User
+		if err := members.Scan(&convID, &user.ID, &user.Name); err != nil {
+			return nil, err
+		}
+		byConv[convID] = append(byConv[convID], user)
+	}
+	if err := members.Err(); err != nil {
+		return nil, err
+	}
+	for i := range output {
+		output[i].Members = byConv[output[i].ID]
+	}
+	return output, nil
+}
+
+// conversationColumns selects a conversation of the member $1 with its
+// unread count and last message.
+const conversationColumns = `
+	SELECT c.id, c.created_at,
+	(SELECT COUNT(*) FROM messages m
+		WHERE m.conversation_id = c.id AND m.id > cm.last_read_id AND m.sender_id <> $1),
+	lm.id, lm.sender_id, lm.content, lm.created_at
+	FROM conversation_members cm
+	JOIN conversations c ON c.id = cm.conversation_id
+	LEFT JOIN LATERAL (
+		SELECT id, sender_id, content, created_at
+		FROM messages
+		WHERE conversation_id = c.id
+		ORDER BY id DESC
+		LIMIT 1
+	) lm ON true
+`
+
+// GetConversations lists the conversations of a user, most recently
+// active first.
+func (c *ConversationStore) GetConversations(ctx context.Context, userID int64) ([]Conversation, error) {
+	query := conversationColumns + `
+		WHERE cm.userid = $1
+		ORDER BY COALESCE(lm.created_at, c.created_at) DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return c.scanConversations(ctx, rows)
+}
+
+func (c *ConversationStore) GetConversation(ctx context.Context, convID, userID int64) (*Conversation, error) {
+	query := conversationColumns + `
+		WHERE cm.userid = $1 AND cm.conversation_id = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, query, userID, convID)
+	if err != nil {
+		return nil, err
+	}
+	convs, err := c.scanConversations(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(convs) == 0 {
+		return nil, ErrNotFound
+	}
+	return &convs[0], nil
+}
+
+// GetMessages returns a page of the history of a conversation, newest
+// first. Before is the id of the last message of the previous page, zero
+// for the first page.
+func (c *ConversationStore) GetMessages(ctx context.Context, convID, userID, before int64,
+	limit int) ([]Message, error) {
+	query := `
+		SELECT m.id, m.conversation_id, m.sender_id, m.content, m.created_at
+		FROM messages m
+		JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.userid = $2
+		WHERE m.conversation_id = $1 AND ($3::bigint = 0 OR m.id < $3)
+		ORDER BY m.id DESC
+		LIMIT $4
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, query, convID, userID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var output []Message
+	for rows.Next() {
+		var msg Message
+		err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Content, &msg.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, msg)
+	}
+	return output, rows.Err()
+}
+
+// members returns the members of a conversation, or ErrNotFound when
+// userID is not one of them.
+func (c *ConversationStore) members(ctx context.Context, q queryer, convID, userID int64) ([]int64, error) {
+	query := `
+		SELECT userid FROM conversation_members
+		WHERE conversation_id = $1
+	`
+	rows, err := q.QueryContext(ctx, query, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var output []int64
+	isMember := false
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		isMember = isMember || id == userID
+		output = append(output, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, ErrNotFound
+	}
+	return output, nil
+}
+
+// SendMessage stores a message and pushes it to every member, the sender
+// included so that their other sessions stay in sync.
+func (c *ConversationStore) SendMessage(ctx context.Context, msg *Message) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(c.db, ctx, func(tx *sql.Tx) error {
+		members, err := c.members(ctx, tx, msg.ConversationID, msg.SenderID)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO messages (conversation_id, sender_id, content)
+			SELECT $1::bigint, $2::bigint, $3::varchar
+			WHERE NOT EXISTS (
+				SELECT 1 FROM conversation_members cm
+				WHERE cm.conversation_id = $1 AND cm.userid <> $2
+				AND NOT ` + dmAllowed("$2", "cm.userid") + `
+			)
+			RETURNING id, created_at
+		`
+		err = tx.QueryRowContext(ctx, query, msg.ConversationID, msg.SenderID, msg.Content).Scan(
+			&msg.ID,
+			&msg.CreatedAt,
+		)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrDMNotAllowed
+			}
+			return err
+		}
+
+		// Senders have read everything up to their own message
+		query = `
+			UPDATE conversation_members
+			SET last_read_id = $3
+			WHERE conversation_id = $1 AND userid = $2
+		`
+		if _, err = tx.ExecContext(ctx, query, msg.ConversationID, msg.SenderID, msg.ID); err != nil {
+			return err
+		}
+
+		return emit(ctx, tx, EventMessage, msg, members)
+	})
+}
+
+// MarkRead moves the read marker of a member forward and sends a read
+// receipt to the other members.
+func (c *ConversationStore) MarkRead(ctx context.Context, receipt *ReadReceipt) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(c.db, ctx, func(tx *sql.Tx) error {
+		members, err := c.members(ctx, tx, receipt.ConversationID, receipt.UserID)
+		if err != nil {
+			return err
+		}
+
+		// only a message of the conversation can be marked read
+		var exists bool
+		query := `SELECT EXISTS (SELECT 1 FROM messages WHERE id = $1 AND conversation_id = $2)`
+		err = tx.QueryRowContext(ctx, query, receipt.MessageID, receipt.ConversationID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+
+		query = `
+			UPDATE conversation_members
+			SET last_read_id = $3
+			WHERE conversation_id = $1 AND userid = $2 AND last_read_id < $3
+		`
+		result, err := tx.ExecContext(ctx, query, receipt.ConversationID, receipt.UserID, receipt.MessageID)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return nil
+		}
+
+		return emit(ctx, tx, EventRead, receipt, others(members, receipt.UserID))
+	})
+}
+
+// Typing lets the other members of a conversation know that a user is
+// typing. The indicator is not stored, nor sent to the members the user
+// blocked or was blocked by.
+func (c *ConversationStore) Typing(ctx context.Context, typing *Typing) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	members, err := c.members(ctx, c.db, typing.ConversationID, typing.UserID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		SELECT m FROM unnest($1::bigint[]) AS m
+		WHERE NOT ` + blocked("m", "$2::bigint") + `
+	`
+	rows, err := c.db.QueryContext(ctx, query, pq.Array(others(members, typing.UserID)), typing.UserID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var recipients []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		recipients = append(recipients, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+	return signal(ctx, c.db, EventTyping, typing, recipients)
+}
+
+func (c *ConversationStore) SetMutualsOnly(ctx context.Context, userID int64, mutualsOnly bool) error {
+	query := `
+		UPDATE users
+		SET dm_mutuals_only = $2
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, query, userID, mutualsOnly)
+	return err
+}
+
+func others(members []int64, userID int64) []int64 {
+	output := make([]int64, 0, len(members))
+	for _, id := range members {
+		if id != userID {
+			output = append(output, id)
+		}
+	}
+	return output
+}
@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+const (
+	NotifyFollow        = "follow"
+	NotifyFollowRequest = "follow_request"
+	NotifyFollowAccept  = "follow_accept"
+	NotifyComment       = "comment"
+	NotifyReaction      = "reaction"
+	NotifyMention       = "mention"
+)
+
+// NotificationTypes lists every type a user can turn on or off.
+var NotificationTypes = []string{
+	NotifyFollow, NotifyFollowRequest, NotifyFollowAccept,
+	NotifyComment, NotifyReaction, NotifyMention,
+}
+
+type Notification struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"userid"`
+	ActorID   int64  `json:"actor_id"`
+	Type      string `json:"type"`
+	PostID    int64  `json:"postid,omitempty"`
+	CommentID int64  `json:"commentid,omitempty"`
+	Read      bool   `json:"read"`
+	CreatedAt string `json:"created_at"`
+	Actor     User   `json:"actor"`
+}
+
+type NotificationPreference struct {
+	Type    string `json:"type" validate:"oneof=follow follow_request follow_accept comment reaction mention"`
+	Enabled bool   `json:"enabled"`
+}
+
+// NotificationQuery selects a page of notifications. Before is the id of
+// the last notification of the previous page, zero for the first page.
+type NotificationQuery struct {
+	Unread bool
+	Before int64
+	Limit  int
+}
+
+type NotificationStore struct {
+	db *sql.DB
+}
+
+// notify records a notification as part of tx and pushes it to the
+// recipient's stream. Nothing is recorded when users act on their own
+// content, turned this type of notification off, or when the recipient
+// blocked or muted the actor.
+func notify(ctx context.Context, tx *sql.Tx, n *Notification) error {
+	query := `
+		INSERT INTO notifications (userid, actor_id, type, postid, commentid)
+		SELECT $1::bigint, $2::bigint, $3::varchar, NULLIF($4::bigint, 0), NULLIF($5::bigint, 0)
+		WHERE $1::bigint <> $2::bigint AND NOT EXISTS (
+			SELECT 1 FROM notification_preferences np
+			WHERE np.userid = $1::bigint AND np.type = $3::varchar AND NOT np.enabled
+		) AND NOT ` + blocked("$1::bigint", "$2::bigint") + `
+		AND NOT ` + muted("$1::bigint", "$2::bigint") + `
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	err := tx.QueryRowContext(ctx, query, n.UserID, n.ActorID, n.Type, n.PostID, n.CommentID).Scan(
+		&n.ID,
+		&n.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	return emit(ctx, tx, EventNotification, n, []int64{n.UserID})
+}
+
+func (n *NotificationStore) GetNotifications(ctx context.Context, userID int64,
+	nq *NotificationQuery) ([]Notification, error) {
+	query := `
+		SELECT n.id, n.userid, n.actor_id, n.type, COALESCE(n.postid, 0),
+		COALESCE(n.commentid, 0), n.read_at IS NOT NULL, n.created_at, u.id, u.name
+		FROM notifications n
+		JOIN users u ON u.id = n.actor_id
+		WHERE n.userid = $1 AND (NOT $2 OR n.read_at IS NULL) AND ($3::bigint = 0 OR n.id < $3)
+		ORDER BY n.id DESC
+		LIMIT $4
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := n.db.QueryContext(ctx, query, userID, nq.Unread, nq.Before, nq.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var output []Notification
+	for rows.Next() {
+		var nt Notification
+		err := rows.Scan(
+			&nt.ID,
+			&nt.UserID,
+			&nt.ActorID,
+			&nt.Type,
+			&nt.PostID,
+			&nt.CommentID,
+			&nt.Read,
+			&nt.CreatedAt,
+			&nt.Actor.ID,
+			&nt.Actor.Name,
+		)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, nt)
+	}
+	return output, rows.Err()
+}
+
+func (n *NotificationStore) MarkRead(ctx context.Context, userID, notificationID int64) error {
+	query := `
+		UPDATE notifications
+		SET read_at = COALESCE(read_at, now())
+		WHERE id = $1 AND userid = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	result, err := n.db.ExecContext(ctx, query, notificationID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (n *NotificationStore) MarkAllRead(ctx context.Context, userID int64) error {
+	query := `
+		UPDATE notifications
+		SET read_at = now()
+		WHERE userid = $1 AND read_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	_, err := n.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// GetPreferences returns a preference for every notification type,
+// types the user never changed are enabled.
+func (n *NotificationStore) GetPreferences(ctx context.Context, userID int64) ([]NotificationPreference, error) {
+	query := `
+		SELECT t.type, COALESCE(np.enabled, true)
+		FROM unnest($2::varchar[]) AS t(type)
+		LEFT JOIN notification_preferences np ON np.type = t.type AND np.userid = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := n.db.QueryContext(ctx, query, userID, pq.Array(NotificationTypes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var output []NotificationPreference
+	for rows.Next() {
+		var pref NotificationPreference
+		if err := rows.Scan(&pref.Type, &pref.Enabled); err != nil {
+			return nil, err
+		}
+		output = append(output, pref)
+	}
+	return output, rows.Err()
+}
+
+func (n *NotificationStore) SetPreferences(ctx context.Context, userID int64,
+	prefs []NotificationPreference) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(n.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO notification_preferences (userid, type, enabled)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (userid, type) DO UPDATE SET enabled = EXCLUDED.enabled
+		`
+		for _, pref := range prefs {
+			_, err := tx.ExecContext(ctx, query, userID, pref.Type, pref.Enabled)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -3,76 +3,392 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
+	"github.com/Alter-Sitanshu/learning_Go/internal/entities"
 	"github.com/lib/pq"
 )
 
+// A post is written as a draft, scheduled to be published at PublishAt or
+// published right away. Until it is published only its author sees it.
+const (
+	PostDraft     = "draft"
+	PostScheduled = "scheduled"
+	PostPublished = "published"
+)
+
+var ErrDraftConflict = errors.New("draft was published or edited meanwhile")
+
 type Post struct {
-	ID        int64     `json:"id"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	UserID    int64     `json:"userid"`
-	Tags      []string  `json:"tags"`
-	CreatedAt string    `json:"created_at"`
-	UpdatedAt string    `json:"updated_at,omitempty"`
-	Version   int       `json:"version,omitempty"`
-	Comments  []Comment `json:"comments"`
-	User      User      `json:"user"`
+	ID      int64  `json:"id"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	// Format is how Content is written, ContentHTML its sanitized rendering
+	Format      string   `json:"format"`
+	ContentHTML string   `json:"content_html"`
+	UserID      int64    `json:"userid"`
+	Tags        []string `json:"tags"`
+	Language    string   `json:"language,omitempty"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at,omitempty"`
+	Version     int      `json:"version,omitempty"`
+	RepostCount int      `json:"repost_count"`
+	Status      string   `json:"status"`
+	// PublishAt is when a scheduled post is published
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	Media     []Media    `json:"media"`
+	// Previews of the links of the content, once fetched
+	Previews []LinkPreview `json:"previews"`
+	Comments []Comment     `json:"comments"`
+	User     User          `json:"user"`
+
+	Entities *entities.Entities `json:"entities,omitempty"`
+}
+
+// loadAttached sets what is attached to posts, their media and the
+// previews of their links, with the same queries for all of them.
+func loadAttached(ctx context.Context, q queryer, posts []*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+
+	media, err := attachmentsOf(ctx, q, ids)
+	if err != nil {
+		return err
+	}
+	previews, err := previewsOf(ctx, q, ids)
+	if err != nil {
+		return err
+	}
+	for _, post := range posts {
+		post.Media = append([]Media{}, media[post.ID]...)
+		post.Previews = append([]LinkPreview{}, previews[post.ID]...)
+	}
+	return nil
+}
+
+// feedPosts returns the posts of the entries of feed.
+func feedPosts(feed []Feed) []*Post {
+	posts := make([]*Post, len(feed))
+	for i := range feed {
+		posts[i] = &feed[i].Post
+	}
+	return posts
+}
+
+// A PostRevision is a version of a published post as it was before it was
+// edited.
+type PostRevision struct {
+	PostID   int64    `json:"postid"`
+	Version  int      `json:"version"`
+	Title    string   `json:"title"`
+	Content  string   `json:"content"`
+	Format   string   `json:"format"`
+	Tags     []string `json:"tags"`
+	EditedAt string   `json:"edited_at"`
 }
 
 type PostStore struct {
 	db *sql.DB
 }
 
-func (p *PostStore) GetPostByID(ctx context.Context, id int64) (*Post, error) {
+// canSee is an SQL condition that holds when viewer may see the posts of
+// author: the account is active, and either public, followed by viewer or
+// viewer's own.
+func canSee(author, viewer string) string {
+	return `((SELECT is_active FROM users WHERE id = ` + author + `) AND
+		(` + author + ` = ` + viewer + `
+		OR NOT (SELECT is_private FROM users WHERE id = ` + author + `)
+		OR EXISTS (SELECT 1 FROM followers WHERE userid = ` + author + ` AND follower_id = ` + viewer + `)))`
+}
+
+// GetPostByID returns the post if viewerID is allowed to see it, posts are
+// hidden between users that blocked each other and unpublished posts from
+// everyone but their author.
+func (p *PostStore) GetPostByID(ctx context.Context, id, viewerID int64) (*Post, error) {
 	query := `
-		SELECT id, title, content, userid, tags, created_at
+		SELECT id, title, content, format, content_html, userid, tags, language::text, created_at, repost_count,
+		version, status, publish_at
 	    FROM posts 
-		WHERE id=$1
+		WHERE id=$1 AND ` + canSee("posts.userid", "$2") + `
+		AND NOT ` + blocked("posts.userid", "$2") + `
+		AND (status = 'published' OR userid = $2)
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
 	defer cancel()
 
 	var post Post
-	err := p.db.QueryRowContext(ctx, query, id).Scan(
+	err := p.db.QueryRowContext(ctx, query, id, viewerID).Scan(
 		&post.ID,
 		&post.Title,
 		&post.Content,
+		&post.Format,
+		&post.ContentHTML,
 		&post.UserID,
 		pq.Array(&post.Tags),
+		&post.Language,
 		&post.CreatedAt,
+		&post.RepostCount,
+		&post.Version,
+		&post.Status,
+		&post.PublishAt,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	post.Media, err = getAttachments(ctx, p.db, post.ID)
+	if err != nil {
+		return nil, err
+	}
+	post.Previews, err = getPreviews(ctx, p.db, post.ID)
+	if err != nil {
+		return nil, err
+	}
 	return &post, nil
 }
 
+// Create stores the post with the media of post.Media attached, drafts
+// and scheduled posts are kept from the followers of the author, and
+// their links from being previewed, until they are published.
 func (p *PostStore) Create(ctx context.Context, post *Post) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(p.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO posts (title, content, format, content_html, userid, tags, language,
+			status, publish_at)
+			VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at, updated_at
+		`
+		err := tx.QueryRowContext(ctx, query,
+			post.Title,
+			post.Content,
+			post.Format,
+			post.ContentHTML,
+			post.UserID,
+			pq.Array(post.Tags),
+			post.Language,
+			post.Status,
+			post.PublishAt,
+		).Scan(
+			&post.ID,
+			&post.CreatedAt,
+			&post.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if err := attachMedia(ctx, tx, post); err != nil {
+			return err
+		}
+
+		post.Previews = []LinkPreview{}
+		if post.Status != PostPublished {
+			return nil
+		}
+		return publishPost(ctx, tx, post)
+	})
+}
+
+// publishPost makes a post that was just published, as part of tx, reach
+// its tag pages, the users it mentions and the timelines and streams of
+// the followers of its author. The mentions of post.Entities are resolved.
+func publishPost(ctx context.Context, tx *sql.Tx, post *Post) error {
+	if err := linkPost(ctx, tx, post); err != nil {
+		return err
+	}
+	if err := tagPost(ctx, tx, post.ID, post.Tags); err != nil {
+		return err
+	}
+	ents := entities.Parse(post.Content)
+	if err := recordMentions(ctx, tx, post.UserID, post.ID, 0, &ents); err != nil {
+		return err
+	}
+	post.Entities = &ents
+	if err := enqueueFanOut(ctx, tx, post.ID, post.UserID, post.CreatedAt); err != nil {
+		return err
+	}
+	return announcePost(ctx, tx, post)
+}
+
+// UpdateDraft saves the changes to a draft or scheduled post, which is
+// published when its status is set to published. It returns
+// ErrDraftConflict when the post was published or edited since it was
+// read.
+func (p *PostStore) UpdateDraft(ctx context.Context, post *Post) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(p.db, ctx, func(tx *sql.Tx) error {
+		// a post is dated by its publication
+		query := `
+			UPDATE posts
+			SET title = $1, content = $2, tags = $3, status = $4, publish_at = $5,
+			format = $8, content_html = $9, version = version + 1, updated_at = now(),
+			created_at = CASE WHEN $4 = 'published' THEN now() ELSE created_at END
+			WHERE id = $6 AND version = $7 AND status <> 'published'
+			RETURNING created_at, updated_at, version
+		`
+		err := tx.QueryRowContext(ctx, query,
+			post.Title,
+			post.Content,
+			pq.Array(post.Tags),
+			post.Status,
+			post.PublishAt,
+			post.ID,
+			post.Version,
+			post.Format,
+			post.ContentHTML,
+		).Scan(
+			&post.CreatedAt,
+			&post.UpdatedAt,
+			&post.Version,
+		)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrDraftConflict
+			}
+			return err
+		}
+
+		post.Previews = []LinkPreview{}
+		if post.Status != PostPublished {
+			return nil
+		}
+		return publishPost(ctx, tx, post)
+	})
+}
+
+// GetDrafts returns the draft and scheduled posts of userID, the most
+// recently created first, before the id of the last post of the previous
+// page.
+func (p *PostStore) GetDrafts(ctx context.Context, userID int64, after cursor.Key, limit int) ([]Post, error) {
 	query := `
-		INSERT INTO posts (title, content, userid, tags)
-		VALUES($1, $2, $3, $4) RETURNING id, created_at, updated_at
+		SELECT id, title, content, format, content_html, userid, tags, language::text, created_at, updated_at,
+		version, status, publish_at
+		FROM posts
+		WHERE userid = $1 AND status <> 'published'
+		AND ($2::bigint = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
 	defer cancel()
 
-	err := p.db.QueryRowContext(ctx, query,
-		post.Title,
-		post.Content,
-		post.UserID,
-		pq.Array(post.Tags),
-	).Scan(
-		&post.ID,
-		&post.CreatedAt,
-		&post.UpdatedAt,
-	)
+	rows, err := p.db.QueryContext(ctx, query, userID, after.ID, limit)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	defer rows.Close()
+
+	drafts := []Post{}
+	for rows.Next() {
+		var post Post
+		err := rows.Scan(
+			&post.ID,
+			&post.Title,
+			&post.Content,
+			&post.Format,
+			&post.ContentHTML,
+			&post.UserID,
+			pq.Array(&post.Tags),
+			&post.Language,
+			&post.CreatedAt,
+			&post.UpdatedAt,
+			&post.Version,
+			&post.Status,
+			&post.PublishAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	attached := make([]*Post, len(drafts))
+	for i := range drafts {
+		attached[i] = &drafts[i]
+	}
+	return drafts, loadAttached(ctx, p.db, attached)
+}
+
+// PublishDue publishes up to batch scheduled posts whose time has come and
+// returns them. Posts being published by another instance are skipped.
+func (p *PostStore) PublishDue(ctx context.Context, batch int) ([]Post, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var published []Post
+	err := withTx(p.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE posts
+			SET status = 'published', created_at = now(), updated_at = now()
+			WHERE id IN (
+				SELECT id FROM posts
+				WHERE status = 'scheduled' AND publish_at <= now()
+				ORDER BY publish_at
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, title, content, format, content_html, userid, tags, language::text, created_at,
+			updated_at, version, status, publish_at
+		`
+		rows, err := tx.QueryContext(ctx, query, batch)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var post Post
+			err := rows.Scan(
+				&post.ID,
+				&post.Title,
+				&post.Content,
+				&post.Format,
+				&post.ContentHTML,
+				&post.UserID,
+				pq.Array(&post.Tags),
+				&post.Language,
+				&post.CreatedAt,
+				&post.UpdatedAt,
+				&post.Version,
+				&post.Status,
+				&post.PublishAt,
+			)
+			if err != nil {
+				return err
+			}
+			published = append(published, post)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		for i := range published {
+			if err := publishPost(ctx, tx, &published[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return published, nil
 }
 
 func (p *PostStore) DeletePost(ctx context.Context, postID int64) error {
@@ -98,25 +414,49 @@ func (p *PostStore) DeletePost(ctx context.Context, postID int64) error {
 	return nil
 }
 
+// UpdatePost saves the edits of post, its links are replaced by those of
+// post.Previews.
 func (p *PostStore) UpdatePost(ctx context.Context, post *Post) error {
 	query := `
 		UPDATE posts
-		SET title = $1, content = $2, version = version + 1
-		WHERE id = $3 AND version = $4
+		SET title = $1, content = $2, format = $3, content_html = $4, tags = $7,
+		version = version + 1, updated_at = now()
+		WHERE id = $5 AND version = $6
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
 	defer cancel()
 
-	result, err := p.db.ExecContext(ctx, query, post.Title, post.Content, post.ID, post.Version)
-	if err != nil {
-		return err
-	}
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if rows != 1 {
-		return fmt.Errorf("multiple rows affected in db: %d", rows)
-	}
-	return nil
+	return withTx(p.db, ctx, func(tx *sql.Tx) error {
+		// the version being replaced is kept as a revision
+		revision := `
+			INSERT INTO post_revisions (postid, version, title, content, format, tags)
+			SELECT id, COALESCE(version, 0), title, content, format, COALESCE(tags, '{}')
+			FROM posts
+			WHERE id = $1 AND version = $2
+		`
+		if _, err := tx.ExecContext(ctx, revision, post.ID, post.Version); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, query,
+			post.Title, post.Content, post.Format, post.ContentHTML, post.ID, post.Version,
+			pq.Array(post.Tags))
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows != 1 {
+			return fmt.Errorf("multiple rows affected in db: %d", rows)
+		}
+		if post.Status != PostPublished {
+			return nil
+		}
+		if err := retagPost(ctx, tx, post.ID, post.Tags); err != nil {
+			return err
+		}
+		return linkPost(ctx, tx, post)
+	})
 }
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/unfurl"
+	"github.com/lib/pq"
+)
+
+// A preview is fetched once its URL is first linked to, and once more
+// when a post links to it after PreviewTTL.
+const (
+	PreviewPending = "pending"
+	PreviewReady   = "ready"
+	PreviewFailed  = "failed"
+)
+
+const (
+	PreviewTTL = time.Hour * 24 * 7
+	// PreviewClaimTimeout is how long fetching a preview may take before
+	// another run takes it over.
+	PreviewClaimTimeout = time.Minute * 5
+)
+
+// LinkPreview is what the page at URL tells of itself, shown along the
+// posts linking to it.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+type LinkPreviewStore struct {
+	db *sql.DB
+}
+
+// Claim returns the oldest pending preview, taking it over from any run
+// that claimed it more than PreviewClaimTimeout ago. It returns
+// ErrNotFound when nothing is left to fetch.
+func (l *LinkPreviewStore) Claim(ctx context.Context) (*LinkPreview, error) {
+	query := `
+		UPDATE link_previews
+		SET claimed_at = now()
+		WHERE url = (
+			SELECT url FROM link_previews
+			WHERE status = 'pending'
+			AND (claimed_at IS NULL OR claimed_at < now() - make_interval(secs => $1))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING url
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var preview LinkPreview
+	err := l.db.QueryRowContext(ctx, query, PreviewClaimTimeout.Seconds()).Scan(&preview.URL)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &preview, nil
+}
+
+// Complete records the fetched preview.
+func (l *LinkPreviewStore) Complete(ctx context.Context, preview *LinkPreview) error {
+	query := `
+		UPDATE link_previews
+		SET status = 'ready', title = $2, description = $3, image = $4, site_name = $5,
+		fetched_at = now(), claimed_at = NULL
+		WHERE url = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	_, err := l.db.ExecContext(ctx, query,
+		preview.URL,
+		preview.Title,
+		preview.Description,
+		preview.Image,
+		preview.SiteName,
+	)
+	return err
+}
+
+// Fail records that the page at url has no preview, it is tried again
+// after PreviewTTL.
+func (l *LinkPreviewStore) Fail(ctx context.Context, url string) error {
+	query := `
+		UPDATE link_previews
+		SET status = 'failed', fetched_at = now(), claimed_at = NULL
+		WHERE url = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	_, err := l.db.ExecContext(ctx, query, url)
+	return err
+}
+
+// Prune deletes the previews no post has linked to for PreviewTTL.
+func (l *LinkPreviewStore) Prune(ctx context.Context) error {
+	query := `
+		DELETE FROM link_previews lp
+		WHERE lp.created_at < now() - make_interval(secs => $1)
+		AND NOT EXISTS (SELECT 1 FROM post_links WHERE url = lp.url)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	_, err := l.db.ExecContext(ctx, query, PreviewTTL.Seconds())
+	return err
+}
+
+// linkPost replaces the links of the post by those of its content, as
+// part of tx, and fills in the previews already fetched. Stale previews
+// are queued to be fetched again. Only published posts are linked, the
+// URLs of drafts are not fetched.
+func linkPost(ctx context.Context, tx *sql.Tx, post *Post) error {
+	urls := unfurl.ExtractURLs(post.Content)
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM post_links WHERE postid = $1`, post.ID); err != nil {
+		return err
+	}
+	if len(urls) == 0 {
+		post.Previews = []LinkPreview{}
+		return nil
+	}
+
+	query := `
+		INSERT INTO link_previews (url)
+		SELECT unnest($1::text[])
+		ON CONFLICT (url) DO UPDATE
+		SET status = 'pending', claimed_at = NULL
+		WHERE link_previews.status <> 'pending'
+		AND link_previews.fetched_at < now() - make_interval(secs => $2)
+	`
+	if _, err := tx.ExecContext(ctx, query, pq.Array(urls), PreviewTTL.Seconds()); err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO post_links (postid, url, position)
+		SELECT $1::bigint, a.url, a.position
+		FROM unnest($2::text[]) WITH ORDINALITY AS a(url, position)
+	`
+	if _, err := tx.ExecContext(ctx, query, post.ID, pq.Array(urls)); err != nil {
+		return err
+	}
+
+	previews, err := getPreviews(ctx, tx, post.ID)
+	if err != nil {
+		return err
+	}
+	post.Previews = previews
+	return nil
+}
+
+// getPreviews returns the fetched previews of the links of postID, in
+// order.
+func getPreviews(ctx context.Context, q queryer, postID int64) ([]LinkPreview, error) {
+	previews, err := previewsOf(ctx, q, []int64{postID})
+	if err != nil {
+		return nil, err
+	}
+	return append([]LinkPreview{}, previews[postID]...), nil
+}
+
+// previewsOf returns, per post, the fetched previews of the links of
+// postIDs, in order.
+func previewsOf(ctx context.Context, q queryer, postIDs []int64) (map[int64][]LinkPreview, error) {
+	query := `
+		SELECT pl.postid, lp.url, lp.title, lp.description, lp.image, lp.site_name
+		FROM post_links pl
+		JOIN link_previews lp ON lp.url = pl.url
+		WHERE pl.postid = ANY($1) AND lp.fetched_at IS NOT NULL AND lp.status <> 'failed'
+		ORDER BY pl.postid, pl.position
+	`
+	rows, err := q.QueryContext(ctx, query, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	output := make(map[int64][]LinkPreview)
+	for rows.Next() {
+		var postID int64
+		var p LinkPreview
+		if err := rows.Scan(&postID, &p.URL, &p.Title, &p.Description, &p.Image, &p.SiteName); err != nil {
+			return nil, err
+		}
+		output[postID] = append(output[postID], p)
+	}
+	return output, rows.Err()
+}
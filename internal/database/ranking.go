@@ -0,0 +1,259 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Ranked feed orders, next to the chronological asc and desc. Top ranks
+// posts by their engagement and age, relevant also by how much the viewer
+// interacts with their authors.
+const (
+	SortTop      = "top"
+	SortRelevant = "relevant"
+)
+
+const (
+	// RankWindow is how old the last activity on a post may be for it to
+	// be ranked, older posts would score close to nothing anyway.
+	RankWindow = time.Hour * 24 * 14
+
+	// affinityWindow is how far back interactions count towards affinity.
+	affinityWindow = time.Hour * 24 * 90
+)
+
+// RankWeights are the weights of the signals that rank a feed. Comments,
+// reactions and reposts make up the engagement of a post, and weigh the
+// interactions of a viewer with an author in their affinity. A score is
+// halved every HalfLife after the last activity on the post.
+type RankWeights struct {
+	Comments  float64
+	Reactions float64
+	Reposts   float64
+	Affinity  float64
+	HalfLife  time.Duration
+}
+
+var DefaultRankWeights = RankWeights{
+	Comments:  2,
+	Reactions: 1,
+	Reposts:   3,
+	Affinity:  5,
+	HalfLife:  time.Hour * 12,
+}
+
+// Validate reports weights no feed can be ranked with: without a positive
+// half-life every score decays to nothing, or divides by zero.
+func (w RankWeights) Validate() error {
+	if w.HalfLife <= 0 {
+		return errors.New("ranking half-life must be positive")
+	}
+	return nil
+}
+
+// Ranking is how the score of a ranked feed entry was made up:
+// Score = (1 + Engagement + affinity weight * Affinity) * Decay.
+// Affinity only counts in the relevant order.
+type Ranking struct {
+	Score      float64 `json:"score"`
+	Engagement float64 `json:"engagement"`
+	Comments   int     `json:"comments"`
+	Reactions  int     `json:"reactions"`
+	Reposts    int     `json:"reposts"`
+	Affinity   float64 `json:"affinity"`
+	AgeHours   float64 `json:"age_hours"`
+	Decay      float64 `json:"decay"`
+}
+
+// rankedFeed returns a page of the home feed of userID ordered by score.
+// Engagement and affinity are read from the scores cached by
+// RefreshRanking, posts not scored yet are scored on the fly. Entries are
+// paged at an offset only.
+func (u *UserStore) rankedFeed(ctx context.Context, userID int64, fq *FilteringQuery) ([]Feed, error) {
+	w := fq.Weights
+	affinity := 0.0
+	if fq.Sort == SortRelevant {
+		affinity = w.Affinity
+	}
+
+	query := `
+		WITH activity AS (` + feedActivity("$1", "$6",
+		"now() - make_interval(secs => $12)", "'infinity'") + `
+		), entries AS (
+			SELECT postid, MAX(at) AS at
+			FROM activity
+			GROUP BY postid
+		), signals AS (
+			SELECT e.postid, e.at,
+			COALESCE(s.comments, p.comment_count) AS comments,
+			COALESCE(s.reactions,
+				(SELECT COUNT(*) FROM reactions r WHERE r.postid = p.id)::int) AS reactions,
+			COALESCE(s.reposts, p.repost_count) AS reposts,
+			COALESCE(s.engagement,
+				$7::float8 * p.comment_count +
+				$8::float8 * (SELECT COUNT(*) FROM reactions r WHERE r.postid = p.id) +
+				$9::float8 * p.repost_count) AS engagement,
+			COALESCE(a.affinity, 0) AS affinity,
+			EXTRACT(EPOCH FROM now() - e.at) / 3600 AS age
+			FROM entries e
+			JOIN posts p ON p.id = e.postid
+			LEFT JOIN post_scores s ON s.postid = p.id
+			LEFT JOIN user_affinities a ON a.userid = $1 AND a.author_id = p.userid
+		), decayed AS (
+			SELECT *, power(0.5, age / $11::float8) AS decay
+			FROM signals
+		), ranked AS (
+			SELECT *, (1 + engagement + $10::float8 * affinity) * decay AS score
+			FROM decayed
+		)
+		SELECT p.id, p.userid, p.title, p.content, p.format, p.content_html, p.tags,
+		p.comment_count, p.repost_count, u.name, p.created_at, k.at,
+		k.score, k.engagement, k.comments, k.reactions, k.reposts, k.affinity,
+		k.age, k.decay
+		FROM ranked k
+		JOIN posts p ON p.id = k.postid
+		LEFT JOIN users u ON u.id = p.userid
+		WHERE ($2 = '' OR p.search @@ to_tsquery(p.language, $2)) AND
+		(p.tags @> $3 OR $3 = '{}') AND ` + canSee("p.userid", "$1") + ` AND
+		NOT ` + blocked("p.userid", "$1") + ` AND NOT ` + muted("$1", "p.userid") + `
+		ORDER BY k.score DESC, p.id DESC
+		LIMIT $4 OFFSET $5
+	`
+	rows, err := u.db.QueryContext(
+		ctx, query, userID, tsquery(fq.Search), pq.Array(fq.Tags), fq.Limit, fq.Offset,
+		CelebrityThreshold, w.Comments, w.Reactions, w.Reposts, affinity,
+		w.HalfLife.Hours(), RankWindow.Seconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var output []Feed
+	var postIDs []int64
+	for rows.Next() {
+		var feed Feed
+		var rank Ranking
+		err := rows.Scan(
+			&feed.Post.ID,
+			&feed.Post.UserID,
+			&feed.Post.Title,
+			&feed.Post.Content,
+			&feed.Post.Format,
+			&feed.Post.ContentHTML,
+			pq.Array(&feed.Post.Tags),
+			&feed.CommentCount,
+			&feed.Post.RepostCount,
+			&feed.Post.User.Name,
+			&feed.Post.CreatedAt,
+			&feed.At,
+			&rank.Score,
+			&rank.Engagement,
+			&rank.Comments,
+			&rank.Reactions,
+			&rank.Reposts,
+			&rank.Affinity,
+			&rank.AgeHours,
+			&rank.Decay,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if fq.Explain {
+			feed.Ranking = &rank
+		}
+		output = append(output, feed)
+		postIDs = append(postIDs, feed.Post.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(postIDs) == 0 {
+		return output, nil
+	}
+
+	if err := loadAttached(ctx, u.db, feedPosts(output)); err != nil {
+		return nil, err
+	}
+	return output, u.attribute(ctx, userID, output, postIDs)
+}
+
+// RefreshRanking recomputes the engagement of the posts active within the
+// rank window and the affinity of every user with the authors they
+// interacted with, using weights. It does nothing and returns ErrLocked
+// when another instance is already refreshing.
+func (u *UserStore) RefreshRanking(ctx context.Context, w RankWeights) error {
+	return withTx(u.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+		defer cancel()
+
+		err := tryLock(ctx, tx, lockRanking)
+		if err != nil {
+			return err
+		}
+
+		// Scores are upserted, the feeds keep reading them while the refresh
+		// runs. Rows left with an older updated_at than the start of the
+		// transaction, now(), fell out of the window and are removed after.
+		query := `
+			WITH recent AS (
+				SELECT id FROM posts WHERE created_at > now() - make_interval(secs => $4)
+				UNION
+				SELECT postid FROM reposts WHERE created_at > now() - make_interval(secs => $4)
+			)
+			INSERT INTO post_scores (postid, comments, reactions, reposts, engagement)
+			SELECT p.id, p.comment_count, COUNT(r.userid), p.repost_count,
+			$1::float8 * p.comment_count + $2::float8 * COUNT(r.userid) +
+			$3::float8 * p.repost_count
+			FROM posts p
+			JOIN recent ON recent.id = p.id
+			LEFT JOIN reactions r ON r.postid = p.id
+			GROUP BY p.id
+			ON CONFLICT (postid) DO UPDATE SET
+			comments = EXCLUDED.comments,
+			reactions = EXCLUDED.reactions,
+			reposts = EXCLUDED.reposts,
+			engagement = EXCLUDED.engagement,
+			updated_at = now()
+		`
+		_, err = tx.ExecContext(ctx, query, w.Comments, w.Reactions, w.Reposts, RankWindow.Seconds())
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM post_scores WHERE updated_at < now()`); err != nil {
+			return err
+		}
+
+		query = `
+			WITH interactions AS (
+				SELECT userid, postid, $1::double precision AS weight
+				FROM comments WHERE created_at > now() - make_interval(secs => $4)
+				UNION ALL
+				SELECT userid, postid, $2::double precision FROM reactions
+				WHERE created_at > now() - make_interval(secs => $4)
+				UNION ALL
+				SELECT userid, postid, $3::double precision FROM reposts
+				WHERE created_at > now() - make_interval(secs => $4)
+			)
+			INSERT INTO user_affinities (userid, author_id, affinity)
+			SELECT i.userid, p.userid, ln(1 + SUM(i.weight))
+			FROM interactions i
+			JOIN posts p ON p.id = i.postid
+			WHERE p.userid <> i.userid
+			GROUP BY i.userid, p.userid
+			ON CONFLICT (userid, author_id) DO UPDATE SET
+			affinity = EXCLUDED.affinity,
+			updated_at = now()
+		`
+		_, err = tx.ExecContext(ctx, query, w.Comments, w.Reactions, w.Reposts, affinityWindow.Seconds())
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM user_affinities WHERE updated_at < now()`)
+		return err
+	})
+}
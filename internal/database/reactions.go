@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Reaction struct {
+	PostID    int64  `json:"postid"`
+	UserID    int64  `json:"userid"`
+	Kind      string `json:"kind"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ReactionStore struct {
+	db *sql.DB
+}
+
+// React sets the reaction of a user on a post, replacing any previous one.
+// The post author is only notified the first time the user reacts.
+func (r *ReactionStore) React(ctx context.Context, reaction *Reaction) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(r.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO reactions (postid, userid, kind)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (postid, userid) DO UPDATE SET kind = EXCLUDED.kind
+			RETURNING created_at, xmax = 0,
+			(SELECT userid FROM posts WHERE id = $1)
+		`
+		var inserted bool
+		var authorID int64
+		err := tx.QueryRowContext(ctx, query,
+			reaction.PostID,
+			reaction.UserID,
+			reaction.Kind,
+		).Scan(
+			&reaction.CreatedAt,
+			&inserted,
+			&authorID,
+		)
+		if err != nil {
+			return err
+		}
+		if !inserted {
+			return nil
+		}
+
+		return notify(ctx, tx, &Notification{
+			UserID:  authorID,
+			ActorID: reaction.UserID,
+			Type:    NotifyReaction,
+			PostID:  reaction.PostID,
+		})
+	})
+}
+
+func (r *ReactionStore) Unreact(ctx context.Context, postID, userID int64) error {
+	query := `
+		DELETE FROM reactions
+		WHERE postid = $1 AND userid = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, query, postID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
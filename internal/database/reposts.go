@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDupliRepost = errors.New("post already reposted")
+	ErrSelfRepost  = errors.New("you can not repost your own post")
+)
+
+// A Repost shares an existing post with the reposter's followers.
+// Quote is optional; when set the repost is shown as a quote post.
+type Repost struct {
+	ID        int64  `json:"id"`
+	PostID    int64  `json:"postid"`
+	UserID    int64  `json:"userid"`
+	Quote     string `json:"quote,omitempty"`
+	CreatedAt string `json:"created_at"`
+	User      User   `json:"user"`
+}
+
+type RepostStore struct {
+	db *sql.DB
+}
+
+// Create stores a repost and queues it for fan-out. Authors can not repost
+// their own posts: the repost would share its timeline entries with the
+// post.
+func (r *RepostStore) Create(ctx context.Context, repost *Repost) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(r.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO reposts (postid, userid, quote)
+			SELECT id, $2, NULLIF($3, '')
+			FROM posts
+			WHERE id = $1 AND userid <> $2
+			RETURNING id, created_at
+		`
+		err := tx.QueryRowContext(ctx, query,
+			repost.PostID,
+			repost.UserID,
+			repost.Quote,
+		).Scan(
+			&repost.ID,
+			&repost.CreatedAt,
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSelfRepost
+		}
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				return ErrDupliRepost
+			}
+			return err
+		}
+
+		return enqueueFanOut(ctx, tx, repost.PostID, repost.UserID, repost.CreatedAt)
+	})
+}
+
+// Delete undoes a repost and takes it off the timelines it was fanned out
+// to. The entries of a self-repost, made before they were rejected, are
+// those of the post itself and are kept.
+func (r *RepostStore) Delete(ctx context.Context, postID, userID int64) error {
+	query := `
+		WITH timeline AS (
+			DELETE FROM timelines
+			WHERE postid = $1 AND actor_id = $2
+			AND NOT EXISTS (SELECT 1 FROM posts WHERE id = $1 AND userid = $2)
+		)
+		DELETE FROM reposts
+		WHERE postid = $1 AND userid = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, query, postID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// followedReposts returns, per post, the reposts made by accounts that
+// userID follows and has not muted. It is used to attribute feed entries.
+func (r *RepostStore) followedReposts(ctx context.Context, userID int64,
+	postIDs []int64) (map[int64][]Repost, error) {
+	query := `
+		SELECT r.id, r.postid, r.userid, COALESCE(r.quote, ''), r.created_at, u.name
+		FROM reposts r
+		JOIN users u ON u.id = r.userid
+		JOIN followers f ON f.userid = r.userid AND f.follower_id = $1
+		WHERE r.postid = ANY($2) AND u.is_active = true AND NOT ` + muted("$1", "r.userid") + `
+		ORDER BY r.created_at DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	output := make(map[int64][]Repost)
+	for rows.Next() {
+		var rp Repost
+		err := rows.Scan(
+			&rp.ID,
+			&rp.PostID,
+			&rp.UserID,
+			&rp.Quote,
+			&rp.CreatedAt,
+			&rp.User.Name,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rp.User.ID = rp.UserID
+		output[rp.PostID] = append(output[rp.PostID], rp)
+	}
+	return output, rows.Err()
+}
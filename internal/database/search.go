@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// UserResult is a user found by a search.
+type UserResult struct {
+	User
+	FollowerCount int `json:"follower_count"`
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchUsers finds the users whose name or display name starts with or
+// resembles uq.Q. Prefix matches come first, then users are ranked by
+// similarity with a boost for their follower count. Inactive and
+// suspended users, and users blocking or blocked by the viewer, are left
+// out.
+func (u *UserStore) SearchUsers(ctx context.Context, viewerID int64, uq *UserSearchQuery) ([]UserResult, error) {
+	query := `
+		WITH matches AS (
+			SELECT u.id, u.name, COALESCE(u.display_name, '') AS display_name,
+			COALESCE(u.bio, '') AS bio, COALESCE(u.avatar_url, '') AS avatar_url,
+			lower(u.name) LIKE $2 || '%' OR lower(u.display_name) LIKE $2 || '%' AS prefix,
+			GREATEST(similarity(lower(u.name), $1), COALESCE(similarity(lower(u.display_name), $1), 0)) AS sim,
+			(SELECT COUNT(*) FROM followers WHERE userid = u.id) AS follower_count
+			FROM users u
+			WHERE u.is_active = true AND u.suspended_at IS NULL
+			AND (lower(u.name) LIKE $2 || '%' OR lower(u.name) % $1
+				OR lower(u.display_name) LIKE $2 || '%' OR lower(u.display_name) % $1)
+			AND NOT ` + blocked("u.id", "$3") + `
+		)
+		SELECT id, name, display_name, bio, avatar_url, follower_count
+		FROM matches
+		ORDER BY prefix DESC, sim + 0.1 * ln(1 + follower_count) DESC, id
+		LIMIT $4 OFFSET $5
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	q := strings.ToLower(uq.Q)
+	rows, err := u.db.QueryContext(ctx, query, q, likeEscaper.Replace(q), viewerID, uq.Limit, uq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []UserResult{}
+	for rows.Next() {
+		var res UserResult
+		err := rows.Scan(
+			&res.ID,
+			&res.Name,
+			&res.DisplayName,
+			&res.Bio,
+			&res.AvatarURL,
+			&res.FollowerCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+
+	return results, rows.Err()
+}
+
+// DefaultLanguage is the text search configuration of posts that do not
+// name theirs.
+const DefaultLanguage = "english"
+
+// PostResult is a post found by a search. Headline is an HTML excerpt of
+// the content with the matches wrapped in <mark> tags.
+type PostResult struct {
+	Post
+	Rank     float64 `json:"rank"`
+	Headline string  `json:"headline"`
+}
+
+// markSel delimits the matches in the excerpts built by ts_headline. The
+// characters are removed from the content beforehand, so that only the
+// matches are marked once the excerpt is escaped.
+var markSel = strings.NewReplacer("\x01", "<mark>", "\x02", "</mark>")
+
+// headline turns an excerpt built by ts_headline from the raw content of a
+// post into HTML.
+func headline(excerpt string) string {
+	return markSel.Replace(html.EscapeString(excerpt))
+}
+
+// tsquery turns a search into the syntax of to_tsquery: every term has to
+// match, the words of a quoted phrase have to follow each other and a word
+// ending with * matches the words it prefixes. Anything but letters and
+// digits is dropped so that the result always parses.
+func tsquery(q string) string {
+	var terms []string
+	for i, part := range strings.Split(q, `"`) {
+		var words []string
+		for _, field := range strings.Fields(part) {
+			prefix := strings.HasSuffix(field, "*")
+			parts := strings.FieldsFunc(field, func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			})
+			if len(parts) == 0 {
+				continue
+			}
+			if prefix {
+				parts[len(parts)-1] += ":*"
+			}
+			words = append(words, parts...)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		// parts at odd indexes are quoted
+		if i%2 == 1 && len(words) > 1 {
+			terms = append(terms, "("+strings.Join(words, " <-> ")+")")
+		} else {
+			terms = append(terms, words...)
+		}
+	}
+	return strings.Join(terms, " & ")
+}
+
+// SearchPosts finds the posts matching pq.Q, stemmed in pq.Lang, ranked
+// by relevance. Posts the viewer may not see, and posts of suspended users
+// or of users blocking or blocked by the viewer, are left out.
+func (p *PostStore) SearchPosts(ctx context.Context, viewerID int64, sq *PostSearchQuery) ([]PostResult, error) {
+	results := []PostResult{}
+	search := tsquery(sq.Q)
+	if search == "" {
+		return results, nil
+	}
+
+	query := `
+		WITH q AS (
+			SELECT to_tsquery($2::regconfig, $1) AS query
+		)
+		SELECT p.id, p.title, p.content, p.format, p.content_html, p.userid, p.tags, p.language::text,
+		p.created_at, p.repost_count, u.name,
+		ts_rank(p.search, q.query) AS rank,
+		ts_headline(p.language, translate(p.content, chr(1) || chr(2), ''), q.query,
+			'StartSel=' || chr(1) || ', StopSel=' || chr(2) || ', MaxFragments=2, MaxWords=30, MinWords=10')
+		FROM posts p
+		CROSS JOIN q
+		JOIN users u ON u.id = p.userid
+		WHERE p.search @@ q.query AND p.status = 'published' AND u.suspended_at IS NULL
+		AND ` + canSee("p.userid", "$3") + `
+		AND NOT ` + blocked("p.userid", "$3") + `
+		ORDER BY rank DESC, p.id DESC
+		LIMIT $4 OFFSET $5
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := p.db.QueryContext(ctx, query, search, sq.Lang, viewerID, sq.Limit, sq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var res PostResult
+		err := rows.Scan(
+			&res.ID,
+			&res.Title,
+			&res.Content,
+			&res.Format,
+			&res.ContentHTML,
+			&res.UserID,
+			pq.Array(&res.Tags),
+			&res.Language,
+			&res.CreatedAt,
+			&res.RepostCount,
+			&res.User.Name,
+			&res.Rank,
+			&res.Headline,
+		)
+		if err != nil {
+			return nil, err
+		}
+		res.Headline = headline(res.Headline)
+		res.User.ID = res.UserID
+		results = append(results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	attached := make([]*Post, len(results))
+	for i := range results {
+		attached[i] = &results[i].Post
+	}
+	return results, loadAttached(ctx, p.db, attached)
+}
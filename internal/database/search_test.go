@@ -0,0 +1,40 @@
+package database
+
+import "testing"
+
+func TestHeadline(t *testing.T) {
+	tests := []struct {
+		name    string
+		excerpt string
+		want    string
+	}{
+		{
+			name:    "plain",
+			excerpt: "learning \x01go\x02 today",
+			want:    "learning <mark>go</mark> today",
+		},
+		{
+			name:    "script post",
+			excerpt: "<script>alert(1)</script> \x01hello\x02",
+			want:    "&lt;script&gt;alert(1)&lt;/script&gt; <mark>hello</mark>",
+		},
+		{
+			name:    "match inside markup",
+			excerpt: "<img src=x onerror=\"\x01alert\x02(1)\">",
+			want:    "&lt;img src=x onerror=&#34;<mark>alert</mark>(1)&#34;&gt;",
+		},
+		{
+			name:    "marks typed by the author",
+			excerpt: "<mark>fake</mark> \x01real\x02",
+			want:    "&lt;mark&gt;fake&lt;/mark&gt; <mark>real</mark>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := headline(tt.excerpt); got != tt.want {
+				t.Errorf("headline(%q) = %q, want %q", tt.excerpt, got, tt.want)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
 )
 
 const QueryTimeOut = time.Minute * 3
@@ -14,26 +16,55 @@ var (
 	ErrTokenExpired = errors.New("invalid or expired token")
 	ErrDupliMail    = errors.New("email already exists")
 	ErrDupliName    = errors.New("name taken")
+
+	ErrSelfFollow       = errors.New("you can not follow yourself")
+	ErrAlreadyFollowing = errors.New("already following this user")
+	ErrAlreadyRequested = errors.New("follow request already sent")
 )
 
 type UserInterface interface {
 	create(context.Context, *sql.Tx, *User) error
 	GetUserByID(context.Context, int64) (*User, error)
 	GetUserByEmail(context.Context, string) (*User, error)
-	Follow(context.Context, int64, int64) error
+	Follow(context.Context, int64, int64) (bool, error)
 	Unfollow(context.Context, int64, int64) error
+	GetProfile(context.Context, int64, int64) (*Profile, error)
+	GetFollowers(context.Context, int64, int64, cursor.Key, int) ([]FollowEntry, error)
+	GetFollowing(context.Context, int64, int64, cursor.Key, int) ([]FollowEntry, error)
+	GetFollowRequests(context.Context, int64, cursor.Key, int) ([]FollowEntry, error)
+	ApproveFollowRequest(context.Context, int64, int64) error
+	RejectFollowRequest(context.Context, int64, int64) error
 	GetFeed(context.Context, int64, *FilteringQuery) ([]Feed, error)
+	SearchUsers(context.Context, int64, *UserSearchQuery) ([]UserResult, error)
+	GetSuggestions(context.Context, int64, int) ([]Suggestion, error)
+	RefreshSuggestions(context.Context) error
+	RefreshRanking(context.Context, RankWeights) error
 	CreateAndInvite(context.Context, *User, string, time.Duration) error
 	authorise(context.Context, *sql.Tx, string, time.Time) (*UserFromToken, error)
 	DeleteUser(context.Context, *User) error
+	DeactivateUser(context.Context, *User) error
+	ReactivateUser(context.Context, *User, time.Time) error
+	SuspendUser(context.Context, int64, int) error
+	UnsuspendUser(context.Context, int64, int) error
+	PurgeDeactivated(context.Context, time.Time) ([]User, []string, error)
 	ActivateUser(context.Context, string, time.Time) error
+	UpdateUser(context.Context, *User) error
+	UpdatePassword(context.Context, *User) error
+	RequestEmailChange(context.Context, int64, string, string, time.Duration) error
+	ConfirmEmailChange(context.Context, string, time.Time) error
 }
 
 type PostInterface interface {
 	Create(context.Context, *Post) error
-	GetPostByID(context.Context, int64) (*Post, error)
+	GetPostByID(context.Context, int64, int64) (*Post, error)
 	DeletePost(context.Context, int64) error
 	UpdatePost(context.Context, *Post) error
+	SearchPosts(context.Context, int64, *PostSearchQuery) ([]PostResult, error)
+	Explore(context.Context, int64, *FilteringQuery) ([]Feed, error)
+	GetSyndicated(context.Context, int64) (*User, []SyndicatedPost, error)
+	UpdateDraft(context.Context, *Post) error
+	GetDrafts(context.Context, int64, cursor.Key, int) ([]Post, error)
+	PublishDue(context.Context, int) ([]Post, error)
 }
 
 type CommentInterface interface {
@@ -41,6 +72,92 @@ type CommentInterface interface {
 	CreateComment(context.Context, *Comment) error
 }
 
+type RepostInterface interface {
+	Create(context.Context, *Repost) error
+	Delete(context.Context, int64, int64) error
+}
+
+type ReactionInterface interface {
+	React(context.Context, *Reaction) error
+	Unreact(context.Context, int64, int64) error
+}
+
+type NotificationInterface interface {
+	GetNotifications(context.Context, int64, *NotificationQuery) ([]Notification, error)
+	MarkRead(context.Context, int64, int64) error
+	MarkAllRead(context.Context, int64) error
+	GetPreferences(context.Context, int64) ([]NotificationPreference, error)
+	SetPreferences(context.Context, int64, []NotificationPreference) error
+}
+
+type StreamInterface interface {
+	Since(context.Context, int64, int64, int) ([]StreamEvent, error)
+	Between(context.Context, StreamNotice, []int64) ([]StreamEvent, error)
+	PostEvents(context.Context, int64, int64, []int64) ([]StreamEvent, error)
+	Prune(context.Context, time.Time) error
+}
+
+type ConversationInterface interface {
+	CreateConversation(context.Context, int64, []int64) (*Conversation, error)
+	GetConversations(context.Context, int64) ([]Conversation, error)
+	GetConversation(context.Context, int64, int64) (*Conversation, error)
+	GetMessages(context.Context, int64, int64, int64, int) ([]Message, error)
+	SendMessage(context.Context, *Message) error
+	MarkRead(context.Context, *ReadReceipt) error
+	Typing(context.Context, *Typing) error
+	SetMutualsOnly(context.Context, int64, bool) error
+}
+
+type BlockInterface interface {
+	Block(context.Context, int64, int64) error
+	Unblock(context.Context, int64, int64) error
+	Mute(context.Context, int64, int64) error
+	Unmute(context.Context, int64, int64) error
+	GetBlocked(context.Context, int64, cursor.Key, int) ([]BlockEntry, error)
+	GetMuted(context.Context, int64, cursor.Key, int) ([]BlockEntry, error)
+}
+
+type ExportInterface interface {
+	Request(context.Context, int64) (*DataExport, error)
+	Claim(context.Context, int) ([]DataExport, error)
+	Complete(context.Context, *DataExport, string, time.Time) error
+	Fail(context.Context, int64) error
+	GetByToken(context.Context, string, time.Time) (*DataExport, error)
+	Expire(context.Context, time.Time) ([]DataExport, error)
+	UserData(context.Context, int64) (*UserData, error)
+}
+
+type TagInterface interface {
+	GetPosts(context.Context, string, int64, cursor.Key, int) ([]TagPost, error)
+	Follow(context.Context, int64, string) error
+	Unfollow(context.Context, int64, string) error
+	GetFollowed(context.Context, int64) ([]Tag, error)
+	GetTrending(context.Context, int) ([]TrendingTag, error)
+	RefreshTrending(context.Context) error
+	GetSyndicated(context.Context, string) ([]SyndicatedPost, error)
+}
+
+type MediaInterface interface {
+	Create(context.Context, *Media) error
+	GetByID(context.Context, int64, int64) (*Media, error)
+	GetVariant(context.Context, int64, string, int64) (*MediaVariant, error)
+	Claim(context.Context) (*Media, error)
+	Complete(context.Context, *Media) error
+	Fail(context.Context, int64) error
+	PurgeOrphans(context.Context, time.Time) ([]string, error)
+}
+
+type LinkPreviewInterface interface {
+	Claim(context.Context) (*LinkPreview, error)
+	Complete(context.Context, *LinkPreview) error
+	Fail(context.Context, string) error
+	Prune(context.Context) error
+}
+
+type TimelineInterface interface {
+	FanOut(context.Context, int) (int, error)
+}
+
 type RoleInterface interface {
 	GetRole(context.Context, string) (*Role, error)
 }
@@ -50,6 +167,17 @@ type Storage interface {
 	Post() PostInterface
 	Comment() CommentInterface
 	Role() RoleInterface
+	Repost() RepostInterface
+	Reaction() ReactionInterface
+	Notification() NotificationInterface
+	Stream() StreamInterface
+	Conversation() ConversationInterface
+	Block() BlockInterface
+	Export() ExportInterface
+	Timeline() TimelineInterface
+	Tag() TagInterface
+	Media() MediaInterface
+	LinkPreview() LinkPreviewInterface
 }
 
 type PostgresRepo struct {
@@ -79,3 +207,47 @@ func (psql *PostgresRepo) Comment() CommentInterface {
 func (psql *PostgresRepo) Role() RoleInterface {
 	return &RoleStore{db: psql.db}
 }
+
+func (psql *PostgresRepo) Repost() RepostInterface {
+	return &RepostStore{db: psql.db}
+}
+
+func (psql *PostgresRepo) Reaction() ReactionInterface {
+	return &ReactionStore{db: psql.db}
+}
+
+func (psql *PostgresRepo) Notification() NotificationInterface {
+	return &NotificationStore{db: psql.db}
+}
+
+func (psql *PostgresRepo) Stream() StreamInterface {
+	return &StreamStore{db: psql.db}
+}
+
+func (psql *PostgresRepo) Conversation() ConversationInterface {
+	return &ConversationStore{db: psql.db}
+}
+
+func (psql *PostgresRepo) Block() BlockInterface {
+	return &BlockStore{db: psql.db}
+}
+
+func (psql *PostgresRepo) Export() ExportInterface {
+	return &ExportStore{db: psql.db}
+}
+
+func (psql *PostgresRepo) Timeline() TimelineInterface {
+	return &TimelineStore{db: psql.db}
+}
+
+func (psql *PostgresRepo) Tag() TagInterface {
+	return &TagStore{db: psql.db}
+}
+
+func (psql *PostgresRepo) Media() MediaInterface {
+	return &MediaStore{db: psql.db}
+}
+
+func (psql *PostgresRepo) LinkPreview() LinkPreviewInterface {
+	return &LinkPreviewStore{db: psql.db}
+}
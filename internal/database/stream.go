@@ -0,0 +1,255 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// StreamChannel is the Postgres channel on which every instance is told
+// about newly stored stream events.
+const StreamChannel = "stream_events"
+
+const (
+	EventPost         = "post"
+	EventComment      = "comment"
+	EventNotification = "notification"
+	EventMessage      = "message"
+	EventTyping       = "typing"
+	EventRead         = "read"
+)
+
+// A StreamEvent is pushed to a connected user. Events are stored per
+// recipient and numbered by Seq, so that a client reconnecting with the
+// sequence number of the last event it saw can be sent what it missed.
+type StreamEvent struct {
+	ID        int64           `json:"id"`
+	UserID    int64           `json:"userid"`
+	Seq       int64           `json:"seq"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt string          `json:"created_at"`
+}
+
+// StreamNotice is the NOTIFY payload sent on StreamChannel. It either
+// covers the ids of the events stored by one statement, carries a
+// transient event, such as a typing indicator, that is never stored, or
+// announces the post Post published by Author.
+type StreamNotice struct {
+	From       int64        `json:"from,omitempty"`
+	To         int64        `json:"to,omitempty"`
+	Transient  *StreamEvent `json:"transient,omitempty"`
+	Recipients []int64      `json:"recipients,omitempty"`
+	Post       int64        `json:"post,omitempty"`
+	Author     int64        `json:"author,omitempty"`
+}
+
+// announce is appended to the INSERT statements of stream events. The
+// notification is only delivered once the surrounding transaction commits.
+const announce = `
+	SELECT pg_notify('` + StreamChannel + `',
+		json_build_object('from', MIN(id), 'to', MAX(id))::text)
+	FROM inserted
+	HAVING COUNT(*) > 0
+`
+
+// emit stores an event for every user in recipients as part of tx. Each
+// event takes the next sequence number of its recipient, whose counter
+// stays locked until tx ends: events of one recipient commit in the order
+// of their numbers, and clients resuming after the number of the last
+// event they saw are sent everything they missed. Counters are locked in
+// the order of the recipient ids, storing events should come last in a
+// transaction so that other users' writes are held up for little time.
+func emit(ctx context.Context, tx *sql.Tx, eventType string, payload any, recipients []int64) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	recipients = slices.Compact(slices.Sorted(slices.Values(recipients)))
+	query := `
+		WITH seqs AS (
+			INSERT INTO stream_sequences AS s (userid, seq)
+			SELECT r, 1 FROM unnest($1::bigint[]) WITH ORDINALITY AS u(r, n)
+			ORDER BY n
+			ON CONFLICT (userid) DO UPDATE SET seq = s.seq + 1
+			RETURNING userid, seq
+		), inserted AS (
+			INSERT INTO stream_events (userid, seq, type, payload)
+			SELECT userid, seq, $2::varchar, $3::jsonb FROM seqs
+			RETURNING id
+		)` + announce
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(recipients), eventType, data)
+	return err
+}
+
+// announcePost tells the instances, once tx commits, that post was
+// published. The event is pushed to the followers of the author connected
+// at that moment only, it is not stored: an author with many followers
+// would otherwise hold up the publication with one row per follower, and
+// the others find the post in their feed.
+func announcePost(ctx context.Context, tx *sql.Tx, post *Post) error {
+	notice, err := json.Marshal(StreamNotice{
+		Post:   post.ID,
+		Author: post.UserID,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, StreamChannel, string(notice))
+	return err
+}
+
+// signal announces a transient event to recipients without storing it.
+// Clients that are not connected at that moment never see it.
+func signal(ctx context.Context, db *sql.DB, eventType string, payload any, recipients []int64) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	notice, err := json.Marshal(StreamNotice{
+		Transient: &StreamEvent{
+			Type:    eventType,
+			Payload: data,
+		},
+		Recipients: recipients,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, StreamChannel, string(notice))
+	return err
+}
+
+type StreamStore struct {
+	db *sql.DB
+}
+
+func scanStreamEvents(rows *sql.Rows) ([]StreamEvent, error) {
+	defer rows.Close()
+
+	var output []StreamEvent
+	for rows.Next() {
+		var ev StreamEvent
+		err := rows.Scan(&ev.ID, &ev.UserID, &ev.Seq, &ev.Type, &ev.Payload, &ev.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, ev)
+	}
+	return output, rows.Err()
+}
+
+// Since returns up to limit events of a user numbered after afterSeq,
+// numbers being drawn in commit order none is skipped.
+func (s *StreamStore) Since(ctx context.Context, userID, afterSeq int64, limit int) ([]StreamEvent, error) {
+	query := `
+		SELECT id, userid, seq, type, payload, created_at
+		FROM stream_events
+		WHERE userid = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanStreamEvents(rows)
+}
+
+// Between returns the events of a range announced on StreamChannel that
+// belong to one of userIDs.
+func (s *StreamStore) Between(ctx context.Context, rng StreamNotice, userIDs []int64) ([]StreamEvent, error) {
+	query := `
+		SELECT id, userid, seq, type, payload, created_at
+		FROM stream_events
+		WHERE id BETWEEN $1 AND $2 AND userid = ANY($3)
+		ORDER BY userid, seq
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, rng.From, rng.To, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	return scanStreamEvents(rows)
+}
+
+// PostEvents returns the transient events announcing the post postID of
+// authorID to those of userIDs who follow the author and did not mute
+// them.
+func (s *StreamStore) PostEvents(ctx context.Context, postID, authorID int64,
+	userIDs []int64) ([]StreamEvent, error) {
+	query := `
+		SELECT follower_id FROM followers
+		WHERE userid = $1 AND follower_id = ANY($2)
+		AND NOT ` + muted("follower_id", "$1") + `
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, authorID, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(recipients) == 0 {
+		return nil, nil
+	}
+
+	// the author sees the post as it was published
+	posts := &PostStore{db: s.db}
+	post, err := posts.GetPostByID(ctx, postID, authorID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// deleted meanwhile
+			return nil, nil
+		}
+		return nil, err
+	}
+	data, err := json.Marshal(post)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]StreamEvent, 0, len(recipients))
+	for _, id := range recipients {
+		output = append(output, StreamEvent{UserID: id, Type: EventPost, Payload: data})
+	}
+	return output, nil
+}
+
+// Prune deletes the events stored before the given time. Clients that
+// reconnect after that can not be sent what they missed.
+func (s *StreamStore) Prune(ctx context.Context, before time.Time) error {
+	query := `
+		DELETE FROM stream_events
+		WHERE created_at < $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, before)
+	return err
+}
@@ -0,0 +1,241 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+const (
+	SuggestMutuals = "mutuals"
+	SuggestPopular = "popular"
+
+	// MaxSuggestions is how many suggestions are kept for each user.
+	MaxSuggestions = 50
+)
+
+// Suggestion is a user that the viewer may want to follow. MutualCount
+// is the number of users followed by the viewer that follow them.
+type Suggestion struct {
+	User
+	MutualCount int    `json:"mutual_count"`
+	Reason      string `json:"reason"`
+}
+
+// GetSuggestions returns the best suggestions computed for userID. Users
+// followed, requested, blocked or muted since the last refresh are left
+// out.
+func (u *UserStore) GetSuggestions(ctx context.Context, userID int64, limit int) ([]Suggestion, error) {
+	query := `
+		SELECT u.id, u.name, COALESCE(u.display_name, ''), COALESCE(u.bio, ''),
+		COALESCE(u.avatar_url, ''), s.mutual_count, s.reason
+		FROM follow_suggestions s
+		JOIN users u ON u.id = s.suggested_id
+		WHERE s.userid = $1 AND u.is_active = true AND u.suspended_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM followers WHERE userid = u.id AND follower_id = $1)
+		AND NOT EXISTS (SELECT 1 FROM follow_requests WHERE userid = u.id AND requester_id = $1)
+		AND NOT ` + blocked("u.id", "$1") + `
+		AND NOT ` + muted("$1", "u.id") + `
+		ORDER BY s.score DESC, u.id
+		LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := u.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []Suggestion{}
+	for rows.Next() {
+		var s Suggestion
+		err := rows.Scan(
+			&s.ID,
+			&s.Name,
+			&s.DisplayName,
+			&s.Bio,
+			&s.AvatarURL,
+			&s.MutualCount,
+			&s.Reason,
+		)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s)
+	}
+
+	return suggestions, rows.Err()
+}
+
+// suggestionBatch is how many users have their suggestions refreshed per
+// transaction.
+const suggestionBatch = 500
+
+// suggestionFanIn is how many of the accounts a user most recently
+// followed count towards friends of friends, on either hop, and how many
+// of the latest posts of a tag are searched for its popular authors.
+const suggestionFanIn = 200
+
+// RefreshSuggestions recomputes the suggestions of every user, batch by
+// batch. Candidates are the users followed by the users one follows,
+// scored by how many of them follow the candidate, and the most followed
+// authors of the tags of the posts one reacted to or commented on. It does
+// nothing and returns ErrLocked when another instance is already
+// refreshing.
+func (u *UserStore) RefreshSuggestions(ctx context.Context) error {
+	// The lock is held by its own transaction for the whole refresh while
+	// each batch is committed on its own, so suggestions are never missing
+	// for long and a failed batch does not undo the others.
+	return withTx(u.db, ctx, func(tx *sql.Tx) error {
+		lockCtx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+		defer cancel()
+
+		err := tryLock(lockCtx, tx, lockSuggestions)
+		if err != nil {
+			return err
+		}
+
+		var after int64
+		for {
+			last, err := u.refreshSuggestions(ctx, after, suggestionBatch)
+			if err != nil {
+				return err
+			}
+			if last == 0 {
+				return nil
+			}
+			after = last
+		}
+	})
+}
+
+// refreshSuggestions recomputes the suggestions of up to batch users with
+// an id above after and returns the last of them, or 0 when there were
+// none. Suggestions are upserted and those not computed again removed.
+func (u *UserStore) refreshSuggestions(ctx context.Context, after int64, batch int) (int64, error) {
+	var last int64
+	err := withTx(u.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+		defer cancel()
+
+		rows, err := tx.QueryContext(ctx, `SELECT id FROM users WHERE id > $1 ORDER BY id LIMIT $2`, after, batch)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		last = ids[len(ids)-1]
+
+		query := `
+			WITH followees AS (
+				SELECT b.userid, f.userid AS followee
+				FROM unnest($1::bigint[]) AS b(userid)
+				CROSS JOIN LATERAL (
+					SELECT userid FROM followers WHERE follower_id = b.userid
+					ORDER BY created_at DESC
+					LIMIT $5
+				) f
+			), fof AS (
+				SELECT fe.userid, f.userid AS suggested_id, COUNT(*) AS mutuals
+				FROM followees fe
+				CROSS JOIN LATERAL (
+					SELECT userid FROM followers WHERE follower_id = fe.followee
+					ORDER BY created_at DESC
+					LIMIT $5
+				) f
+				GROUP BY fe.userid, f.userid
+			), engaged AS (
+				SELECT DISTINCT e.userid, pt.tagid
+				FROM (
+					SELECT userid, postid FROM reactions WHERE userid = ANY($1)
+					UNION
+					SELECT userid, postid FROM comments WHERE userid = ANY($1)
+				) e
+				JOIN post_tags pt ON pt.postid = e.postid
+			), popular AS (
+				SELECT t.tagid, a.userid, a.follower_count
+				FROM (SELECT DISTINCT tagid FROM engaged) t
+				CROSS JOIN LATERAL (
+					SELECT au.id AS userid, au.follower_count
+					FROM users au
+					WHERE au.id IN (
+						SELECT p.userid
+						FROM (
+							SELECT postid FROM post_tags WHERE tagid = t.tagid
+							ORDER BY created_at DESC
+							LIMIT $5
+						) recent
+						JOIN posts p ON p.id = recent.postid
+					)
+					ORDER BY au.follower_count DESC
+					LIMIT 20
+				) a
+			), candidates AS (
+				SELECT userid, suggested_id, mutuals, 0::double precision AS popularity
+				FROM fof
+				UNION ALL
+				SELECT e.userid, p.userid, 0, MAX(ln(1 + p.follower_count))
+				FROM engaged e
+				JOIN popular p ON p.tagid = e.tagid
+				GROUP BY e.userid, p.userid
+			), scored AS (
+				SELECT c.userid, c.suggested_id, SUM(c.mutuals) AS mutual_count,
+				SUM(c.mutuals) + SUM(c.popularity) / 2 AS score,
+				ROW_NUMBER() OVER (
+					PARTITION BY c.userid ORDER BY SUM(c.mutuals) + SUM(c.popularity) / 2 DESC
+				) AS rank
+				FROM candidates c
+				JOIN users u ON u.id = c.suggested_id
+				WHERE c.userid <> c.suggested_id
+				AND u.is_active = true AND u.suspended_at IS NULL
+				AND NOT EXISTS (
+					SELECT 1 FROM followers
+					WHERE userid = c.suggested_id AND follower_id = c.userid
+				)
+				AND NOT ` + blocked("c.userid", "c.suggested_id") + `
+				AND NOT ` + muted("c.userid", "c.suggested_id") + `
+				GROUP BY c.userid, c.suggested_id
+			)
+			INSERT INTO follow_suggestions (userid, suggested_id, mutual_count, score, reason)
+			SELECT userid, suggested_id, mutual_count, score,
+			CASE WHEN mutual_count > 0 THEN $2 ELSE $3 END
+			FROM scored
+			WHERE rank <= $4
+			ON CONFLICT (userid, suggested_id) DO UPDATE SET
+			mutual_count = EXCLUDED.mutual_count,
+			score = EXCLUDED.score,
+			reason = EXCLUDED.reason,
+			computed_at = now()
+		`
+		_, err = tx.ExecContext(
+			ctx, query, pq.Array(ids), SuggestMutuals, SuggestPopular,
+			MaxSuggestions, suggestionFanIn,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(
+			ctx, `DELETE FROM follow_suggestions WHERE userid = ANY($1) AND computed_at < now()`,
+			pq.Array(ids),
+		)
+		return err
+	})
+	return last, err
+}
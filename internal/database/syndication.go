@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// MaxSyndicated is how many of the latest posts an RSS or Atom feed
+// carries.
+const MaxSyndicated = 50
+
+// SyndicatedPost is a post of a public account as it is published in RSS
+// and Atom feeds. Content is its sanitized HTML, Author is the display
+// name of its author, or their name when they have none.
+type SyndicatedPost struct {
+	ID        int64
+	Title     string
+	Content   string
+	Author    string
+	Media     []Media
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// publicAuthor is an SQL condition that holds when the posts of author
+// may be published to anyone: the account is active, public and not
+// suspended.
+func publicAuthor(author string) string {
+	return `EXISTS (SELECT 1 FROM users WHERE id = ` + author + ` AND is_active = true
+		AND NOT is_private AND suspended_at IS NULL)`
+}
+
+// GetSyndicated returns the public account userID and its latest posts. It
+// returns ErrNotFound when the account can not be syndicated.
+func (p *PostStore) GetSyndicated(ctx context.Context, userID int64) (*User, []SyndicatedPost, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT id, name, COALESCE(display_name, '')
+		FROM users
+		WHERE id = $1 AND ` + publicAuthor("users.id") + `
+	`
+	var user User
+	err := p.db.QueryRowContext(ctx, query, userID).Scan(&user.ID, &user.Name, &user.DisplayName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+
+	query = `
+		SELECT p.id, p.title, p.content_html, COALESCE(NULLIF(u.display_name, ''), u.name),
+		p.created_at, p.updated_at
+		FROM posts p
+		JOIN users u ON u.id = p.userid
+		WHERE p.userid = $1 AND p.status = 'published'
+		ORDER BY p.created_at DESC, p.id DESC
+		LIMIT $2
+	`
+	posts, err := querySyndicated(ctx, p.db, query, userID, MaxSyndicated)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &user, posts, nil
+}
+
+// GetSyndicated returns the latest posts tagged with slug by public
+// accounts. It returns ErrNotFound when no post was ever tagged with slug.
+func (t *TagStore) GetSyndicated(ctx context.Context, slug string) ([]SyndicatedPost, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var exists bool
+	err := t.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM tags WHERE slug = $1)`, slug).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	query := `
+		SELECT p.id, p.title, p.content_html, COALESCE(NULLIF(u.display_name, ''), u.name),
+		p.created_at, p.updated_at
+		FROM tags tg
+		JOIN post_tags pt ON pt.tagid = tg.id
+		JOIN posts p ON p.id = pt.postid
+		JOIN users u ON u.id = p.userid
+		WHERE tg.slug = $1 AND ` + publicAuthor("p.userid") + `
+		ORDER BY pt.created_at DESC, pt.postid DESC
+		LIMIT $2
+	`
+	return querySyndicated(ctx, t.db, query, slug, MaxSyndicated)
+}
+
+func querySyndicated(ctx context.Context, db *sql.DB, query string, args ...any) ([]SyndicatedPost, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []SyndicatedPost{}
+	for rows.Next() {
+		var p SyndicatedPost
+		err := rows.Scan(
+			&p.ID,
+			&p.Title,
+			&p.Content,
+			&p.Author,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	ids := make([]int64, len(posts))
+	for i := range posts {
+		ids[i] = posts[i].ID
+	}
+	media, err := attachmentsOf(ctx, db, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range posts {
+		posts[i].Media = append([]Media{}, media[posts[i].ID]...)
+	}
+	return posts, nil
+}
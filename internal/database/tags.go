@@ -0,0 +1,321 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
+	"github.com/lib/pq"
+)
+
+const (
+	// TrendingWindow is the sliding window the use of a tag is counted over
+	// to tell whether it is trending.
+	TrendingWindow = time.Hour * 24
+
+	// trendingBaseline is how far back, before the window, the usual use
+	// of a tag is measured.
+	trendingBaseline = time.Hour * 24 * 7
+
+	// MaxTrending is how many trending tags are kept.
+	MaxTrending = 50
+
+	// minTrendingAuthors is how many users have to use a tag within the
+	// window for it to trend.
+	minTrendingAuthors = 3
+)
+
+// A Tag is a canonical hashtag, Slug is lowercase and made of letters,
+// digits and underscores only.
+type Tag struct {
+	ID        int64     `json:"id"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TagPost is a post listed on the page of a tag.
+type TagPost struct {
+	Post
+	TaggedAt time.Time `json:"-"`
+}
+
+// TrendingTag is a tag used by Authors users within the trending window.
+// Score is how far above its usual use that is.
+type TrendingTag struct {
+	Slug    string  `json:"slug"`
+	Authors int     `json:"authors"`
+	Score   float64 `json:"score"`
+}
+
+type TagStore struct {
+	db *sql.DB
+}
+
+// tagPost links postID to tags, as part of tx, creating the tags that do
+// not exist yet. Tags are expected to be slugs.
+func tagPost(ctx context.Context, tx *sql.Tx, postID int64, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	query := `
+		INSERT INTO tags (slug)
+		SELECT unnest($1::text[])
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, query, pq.Array(tags)); err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO post_tags (postid, tagid, created_at)
+		SELECT p.id, t.id, p.created_at
+		FROM posts p
+		JOIN tags t ON t.slug = ANY($2::text[])
+		WHERE p.id = $1
+		ON CONFLICT DO NOTHING
+	`
+	_, err := tx.ExecContext(ctx, query, postID, pq.Array(tags))
+	return err
+}
+
+// retagPost brings the tag pages of an edited post in line with tags, as
+// part of tx.
+func retagPost(ctx context.Context, tx *sql.Tx, postID int64, tags []string) error {
+	query := `
+		DELETE FROM post_tags
+		WHERE postid = $1 AND tagid NOT IN (
+			SELECT id FROM tags WHERE slug = ANY($2::text[])
+		)
+	`
+	if _, err := tx.ExecContext(ctx, query, postID, pq.Array(tags)); err != nil {
+		return err
+	}
+	return tagPost(ctx, tx, postID, tags)
+}
+
+// GetPosts returns the posts tagged with slug that viewerID may see, most
+// recent first, after the key of the last post of the previous page.
+func (t *TagStore) GetPosts(ctx context.Context, slug string, viewerID int64,
+	after cursor.Key, limit int) ([]TagPost, error) {
+	query := `
+		SELECT p.id, p.title, p.content, p.format, p.content_html, p.userid, p.tags, p.created_at,
+		p.repost_count, u.name, pt.created_at
+		FROM tags t
+		JOIN post_tags pt ON pt.tagid = t.id
+		JOIN posts p ON p.id = pt.postid
+		JOIN users u ON u.id = p.userid
+		WHERE t.slug = $1 AND u.suspended_at IS NULL
+		AND ` + canSee("p.userid", "$2") + `
+		AND NOT ` + blocked("p.userid", "$2") + `
+		AND NOT ` + muted("$2", "p.userid") + `
+		AND ($4::bigint = 0 OR (pt.created_at, pt.postid) < ($3::timestamptz, $4))
+		ORDER BY pt.created_at DESC, pt.postid DESC
+		LIMIT $5
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := t.db.QueryContext(ctx, query, slug, viewerID, after.Time, after.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []TagPost{}
+	for rows.Next() {
+		var p TagPost
+		err := rows.Scan(
+			&p.ID,
+			&p.Title,
+			&p.Content,
+			&p.Format,
+			&p.ContentHTML,
+			&p.UserID,
+			pq.Array(&p.Tags),
+			&p.CreatedAt,
+			&p.RepostCount,
+			&p.User.Name,
+			&p.TaggedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		p.User.ID = p.UserID
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	attached := make([]*Post, len(posts))
+	for i := range posts {
+		attached[i] = &posts[i].Post
+	}
+	return posts, loadAttached(ctx, t.db, attached)
+}
+
+// Follow adds the posts tagged with slug to the feed of userID, following
+// a tag twice is not an error. It returns ErrNotFound when no post was
+// ever tagged with slug.
+func (t *TagStore) Follow(ctx context.Context, userID int64, slug string) error {
+	query := `
+		WITH tag AS (
+			SELECT id FROM tags WHERE slug = $2
+		), follow AS (
+			INSERT INTO tag_follows (userid, tagid)
+			SELECT $1::bigint, id FROM tag
+			ON CONFLICT DO NOTHING
+		)
+		SELECT EXISTS (SELECT 1 FROM tag)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var exists bool
+	if err := t.db.QueryRowContext(ctx, query, userID, slug).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (t *TagStore) Unfollow(ctx context.Context, userID int64, slug string) error {
+	query := `
+		DELETE FROM tag_follows
+		WHERE userid = $1 AND tagid = (SELECT id FROM tags WHERE slug = $2)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := t.db.ExecContext(ctx, query, userID, slug)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetFollowed returns the tags userID follows, in alphabetical order.
+func (t *TagStore) GetFollowed(ctx context.Context, userID int64) ([]Tag, error) {
+	query := `
+		SELECT t.id, t.slug, t.created_at
+		FROM tag_follows tf
+		JOIN tags t ON t.id = tf.tagid
+		WHERE tf.userid = $1
+		ORDER BY t.slug
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := t.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Slug, &tag.CreatedAt); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// GetTrending returns the tags trending at the last refresh, the most
+// trending first.
+func (t *TagStore) GetTrending(ctx context.Context, limit int) ([]TrendingTag, error) {
+	query := `
+		SELECT t.slug, tt.authors, tt.score
+		FROM trending_tags tt
+		JOIN tags t ON t.id = tt.tagid
+		ORDER BY tt.score DESC, t.slug
+		LIMIT $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := t.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TrendingTag{}
+	for rows.Next() {
+		var tag TrendingTag
+		if err := rows.Scan(&tag.Slug, &tag.Authors, &tag.Score); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// RefreshTrending recomputes the trending tags. The use of a tag is the
+// number of distinct users that posted with it, so that one account can
+// not make a tag trend. A tag trends when its use within the trending
+// window is well above its usual use over the baseline before it. It does
+// nothing and returns ErrLocked when another instance is already
+// refreshing.
+func (t *TagStore) RefreshTrending(ctx context.Context) error {
+	return withTx(t.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+		defer cancel()
+
+		err := tryLock(ctx, tx, lockTrending)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM trending_tags`); err != nil {
+			return err
+		}
+
+		query := `
+			WITH counts AS (
+				SELECT pt.tagid,
+				COUNT(DISTINCT p.userid) FILTER (
+					WHERE pt.created_at > now() - make_interval(secs => $1::float8)
+				) AS authors,
+				COUNT(DISTINCT p.userid) FILTER (
+					WHERE pt.created_at <= now() - make_interval(secs => $1::float8)
+				) AS baseline
+				FROM post_tags pt
+				JOIN posts p ON p.id = pt.postid
+				JOIN users u ON u.id = p.userid AND u.is_active = true AND u.suspended_at IS NULL
+				WHERE pt.created_at > now() - make_interval(secs => $1::float8 + $2::float8)
+				GROUP BY pt.tagid
+			), scored AS (
+				SELECT tagid, authors,
+				(authors - baseline * $5::float8) / sqrt(baseline * $5::float8 + 1) AS score
+				FROM counts
+				WHERE authors >= $3
+			)
+			INSERT INTO trending_tags (tagid, authors, score)
+			SELECT tagid, authors, score
+			FROM scored
+			WHERE score > 0
+			ORDER BY score DESC
+			LIMIT $4
+		`
+		// the baseline is scaled down to the length of the window
+		ratio := TrendingWindow.Seconds() / trendingBaseline.Seconds()
+		_, err = tx.ExecContext(ctx, query, TrendingWindow.Seconds(),
+			trendingBaseline.Seconds(), minTrendingAuthors, MaxTrending, ratio)
+		return err
+	})
+}
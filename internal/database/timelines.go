@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+const (
+	// CelebrityThreshold is the follower count above which the activities
+	// of an account are not fanned out but pulled into the feeds of its
+	// followers when they are read.
+	CelebrityThreshold = 10_000
+
+	// backfillSize is how many recent activities of an account are copied
+	// to the timeline of a new follower.
+	backfillSize = 200
+)
+
+type TimelineStore struct {
+	db *sql.DB
+}
+
+// enqueueFanOut queues an activity of actorID on postID, as part of tx,
+// to be copied to the timelines of the actor's followers.
+func enqueueFanOut(ctx context.Context, tx *sql.Tx, postID, actorID int64, at string) error {
+	query := `
+		INSERT INTO timeline_queue (postid, actor_id, at)
+		VALUES ($1, $2, $3)
+	`
+	_, err := tx.ExecContext(ctx, query, postID, actorID, at)
+	return err
+}
+
+// FanOut copies up to batch queued activities to the timelines of the
+// followers of their actors and returns how many it took off the queue.
+// Activities of celebrities are dropped, they are pulled instead. Queued
+// activities taken by another instance are skipped.
+func (t *TimelineStore) FanOut(ctx context.Context, batch int) (int, error) {
+	query := `
+		WITH claimed AS (
+			DELETE FROM timeline_queue
+			WHERE id IN (
+				SELECT id FROM timeline_queue
+				ORDER BY id
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING postid, actor_id, at
+		), inserted AS (
+			INSERT INTO timelines (userid, postid, actor_id, at)
+			SELECT f.follower_id, c.postid, c.actor_id, c.at
+			FROM claimed c
+			JOIN users a ON a.id = c.actor_id AND a.follower_count <= $2
+			JOIN followers f ON f.userid = c.actor_id
+			ON CONFLICT DO NOTHING
+		)
+		SELECT COUNT(*) FROM claimed
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var n int
+	err := t.db.QueryRowContext(ctx, query, batch, CelebrityThreshold).Scan(&n)
+	return n, err
+}
+
+// backfill copies the recent activities of targetID to the timeline of
+// its new follower followerID, as part of tx.
+func backfill(ctx context.Context, tx *sql.Tx, followerID, targetID int64) error {
+	query := `
+		INSERT INTO timelines (userid, postid, actor_id, at)
+		SELECT $1::bigint, postid, $2::bigint, at
+		FROM (
+			SELECT id AS postid, created_at AS at FROM posts WHERE userid = $2 AND status = 'published'
+			UNION ALL
+			SELECT postid, created_at FROM reposts WHERE userid = $2
+			ORDER BY at DESC
+			LIMIT $3
+		) recent
+		WHERE (SELECT follower_count FROM users WHERE id = $2) <= $4
+		ON CONFLICT DO NOTHING
+	`
+	_, err := tx.ExecContext(ctx, query, followerID, targetID, backfillSize, CelebrityThreshold)
+	return err
+}
+
+// refan queues the recent activities of actorID, as part of tx, to be
+// fanned out again when losing a follower brings it back to
+// CelebrityThreshold. They were pulled while it had more followers and
+// are missing from their timelines.
+func refan(ctx context.Context, tx *sql.Tx, actorID int64) error {
+	query := `
+		INSERT INTO timeline_queue (postid, actor_id, at)
+		SELECT postid, $1::bigint, at
+		FROM (
+			SELECT id AS postid, created_at AS at FROM posts WHERE userid = $1 AND status = 'published'
+			UNION ALL
+			SELECT postid, created_at FROM reposts WHERE userid = $1
+			ORDER BY at DESC
+			LIMIT $2
+		) recent
+		WHERE (SELECT follower_count FROM users WHERE id = $1) = $3
+	`
+	_, err := tx.ExecContext(ctx, query, actorID, backfillSize, CelebrityThreshold)
+	return err
+}
+
+// unfill removes the activities of actorID from the timeline of userID,
+// as part of tx.
+func unfill(ctx context.Context, tx *sql.Tx, userID, actorID int64) error {
+	query := `
+		DELETE FROM timelines
+		WHERE userid = $1 AND actor_id = $2
+	`
+	_, err := tx.ExecContext(ctx, query, userID, actorID)
+	return err
+}
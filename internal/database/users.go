@@ -5,21 +5,47 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"errors"
+	"slices"
 	"time"
 
+	"github.com/Alter-Sitanshu/learning_Go/internal/cursor"
 	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID       int64    `json:"id,omitempty"`
-	Name     string   `json:"name,omitempty"`
-	Password password `json:"-"`
-	Email    string   `json:"email,omitempty"`
-	Age      int      `json:"age,omitempty"`
-	Gender   byte     `json:"gender,omitempty"` // either 0(M) or 1(F)
-	Active   bool     `json:"is_active,omitempty"`
-	Role     int      `json:"role,omitempty"`
+	ID          int64    `json:"id,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	DisplayName string   `json:"display_name,omitempty"`
+	Bio         string   `json:"bio,omitempty"`
+	AvatarURL   string   `json:"avatar_url,omitempty"`
+	Password    password `json:"-"`
+	Email       string   `json:"email,omitempty"`
+	Age         int      `json:"age,omitempty"`
+	Gender      byte     `json:"gender,omitempty"` // either 0(M) or 1(F)
+	Active      bool     `json:"is_active,omitempty"`
+	Private     bool     `json:"is_private"`
+	Role        int      `json:"role,omitempty"`
+
+	// DeactivatedAt is set while a deactivated account waits for deletion
+	DeactivatedAt *time.Time `json:"-"`
+}
+
+// Profile is a user as seen by another one.
+type Profile struct {
+	User
+	FollowerCount  int  `json:"follower_count"`
+	FollowingCount int  `json:"following_count"`
+	FollowsYou     bool `json:"follows_you"`
+	YouFollow      bool `json:"you_follow"`
+	Requested      bool `json:"requested"`
+}
+
+// FollowEntry is a user in a followers or following listing.
+type FollowEntry struct {
+	User
+	FollowedAt time.Time `json:"followed_at"`
 }
 
 type password struct {
@@ -48,32 +74,62 @@ func (pass *password) CheckPassword(plainPassword string) error {
 	return bcrypt.CompareHashAndPassword(pass.hash, []byte(plainPassword))
 }
 
+// A Feed entry is either a post by a followed user or a post reposted by
+// one. Reposts of the same post are collapsed into a single entry and
+// listed in RepostedBy, most recent first.
 type Feed struct {
-	Post         Post `json:"post"`
-	CommentCount int  `json:"comment_count"`
+	Post         Post      `json:"post"`
+	CommentCount int       `json:"comment_count"`
+	RepostedBy   []Repost  `json:"reposted_by,omitempty"`
+	At           time.Time `json:"activity_at"`
+	// Ranking explains the place of the entry in a ranked feed
+	Ranking *Ranking `json:"ranking,omitempty"`
+}
+
+// reverse turns a sort order or a comparison around.
+var reverse = map[string]string{
+	"asc":  "desc",
+	"desc": "asc",
+	"<":    ">",
+	">":    "<",
 }
 
 type UserStore struct {
 	db *sql.DB
 }
 
-func (u *UserStore) GetUserByID(ctx context.Context, id int64) (*User, error) {
-	query := `
-		SELECT id, name, password, email, age, gender, role
-		FROM users 
-		WHERE id=$1 AND is_active = true
-		RETURNING id,name,email,age,gender
-	`
-	var user User
-	err := u.db.QueryRowContext(ctx, query, id).Scan(
+// userColumns are scanned by scanUser. The gender column is a boolean
+// that is read back as 0 or 1.
+const userColumns = `
+	id, name, COALESCE(display_name, ''), COALESCE(bio, ''), COALESCE(avatar_url, ''),
+	password, email, age, gender::int, is_private, role, deactivated_at
+`
+
+func scanUser(row *sql.Row, user *User) error {
+	return row.Scan(
 		&user.ID,
 		&user.Name,
+		&user.DisplayName,
+		&user.Bio,
+		&user.AvatarURL,
 		&user.Password.hash,
 		&user.Email,
 		&user.Age,
 		&user.Gender,
+		&user.Private,
 		&user.Role,
+		&user.DeactivatedAt,
 	)
+}
+
+func (u *UserStore) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users 
+		WHERE id=$1 AND is_active = true
+	`
+	var user User
+	err := scanUser(u.db.QueryRowContext(ctx, query, id), &user)
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -86,22 +142,16 @@ func (u *UserStore) GetUserByID(ctx context.Context, id int64) (*User, error) {
 	return &user, nil
 }
 
+// GetUserByEmail returns an active user, or a deactivated one so that
+// they can log back in to cancel the deletion of their account.
 func (u *UserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, name, password, email, age, gender, role
+		SELECT ` + userColumns + `
 		FROM users 
-		WHERE email=$1 AND is_active=true
+		WHERE email=$1 AND (is_active=true OR deactivated_at IS NOT NULL)
 	`
 	var user User
-	err := u.db.QueryRowContext(ctx, query, email).Scan(
-		&user.ID,
-		&user.Name,
-		&user.Password.hash,
-		&user.Email,
-		&user.Age,
-		&user.Gender,
-		&user.Role,
-	)
+	err := scanUser(u.db.QueryRowContext(ctx, query, email), &user)
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -117,7 +167,7 @@ func (u *UserStore) GetUserByEmail(ctx context.Context, email string) (*User, er
 func (u *UserStore) create(ctx context.Context, tx *sql.Tx, user *User) error {
 	query := `
 		INSERT INTO users (name, password, email, age, gender, role)
-		VALUES($1, $2, $3, $4, $5) RETURNING id
+		VALUES($1, $2, $3, $4, $5, $6) RETURNING id
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
 	defer cancel()
@@ -133,82 +183,416 @@ func (u *UserStore) create(ctx context.Context, tx *sql.Tx, user *User) error {
 		&user.ID,
 	)
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_name_key"`:
-			return ErrDupliName
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDupliMail
-		}
-		return err
+		return uniqueUserErr(err)
 	}
 	return nil
 }
 
-func (u *UserStore) Follow(ctx context.Context, targetID, userID int64) error {
+// uniqueUserErr maps violations of the unique name and email constraints
+// of users to ErrDupliName and ErrDupliMail.
+func uniqueUserErr(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "users_name_key"`:
+		return ErrDupliName
+	case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		return ErrDupliMail
+	}
+	return err
+}
+
+// Follow makes userID a follower of targetID. Private accounts have to
+// approve their followers first, for them a follow request is recorded
+// instead and requested is true.
+func (u *UserStore) Follow(ctx context.Context, targetID, userID int64) (requested bool, err error) {
+	if targetID == userID {
+		return false, ErrSelfFollow
+	}
+	err = withTx(u.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+		defer cancel()
+
+		var following, isBlocked bool
+		query := `
+			SELECT is_private,
+			EXISTS (SELECT 1 FROM followers WHERE userid = $1 AND follower_id = $2),
+			` + blocked("$1", "$2") + `
+			FROM users
+			WHERE id = $1 AND is_active = true
+		`
+		err := tx.QueryRowContext(ctx, query, targetID, userID).Scan(&requested, &following, &isBlocked)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+		if isBlocked {
+			return ErrBlocked
+		}
+		if following {
+			return ErrAlreadyFollowing
+		}
+
+		kind := NotifyFollow
+		query = `
+			INSERT INTO followers (userid, follower_id)
+			VALUES ($1, $2)
+		`
+		if requested {
+			kind = NotifyFollowRequest
+			query = `
+				INSERT INTO follow_requests (userid, requester_id)
+				VALUES ($1, $2)
+			`
+		}
+		_, err = tx.ExecContext(ctx, query, targetID, userID)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				if requested {
+					return ErrAlreadyRequested
+				}
+				return ErrAlreadyFollowing
+			}
+			return err
+		}
+		if !requested {
+			if err := backfill(ctx, tx, userID, targetID); err != nil {
+				return err
+			}
+		}
+
+		return notify(ctx, tx, &Notification{
+			UserID:  targetID,
+			ActorID: userID,
+			Type:    kind,
+		})
+	})
+	return requested, err
+}
+
+// Unfollow stops userID from following targetID, a pending follow
+// request is withdrawn as well and the activities of targetID leave the
+// timeline of userID. Should targetID drop back to CelebrityThreshold its
+// recent activities are fanned out again.
+func (u *UserStore) Unfollow(ctx context.Context, targetID, userID int64) error {
 	query := `
-		INSERT INTO followers (userid, follower_id)
-		VALUES ($1, $2)
+		WITH request AS (
+			DELETE FROM follow_requests
+			WHERE userid = $1 AND requester_id = $2
+			RETURNING 1
+		), follow AS (
+			DELETE FROM followers
+			WHERE userid = $1 AND follower_id = $2
+			RETURNING 1
+		), timeline AS (
+			DELETE FROM timelines
+			WHERE userid = $2 AND actor_id = $1
+		)
+		SELECT (SELECT COUNT(*) FROM request), (SELECT COUNT(*) FROM follow)
 	`
-	_, err := u.db.ExecContext(ctx, query, targetID, userID)
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(u.db, ctx, func(tx *sql.Tx) error {
+		var requests, follows int
+		err := tx.QueryRowContext(ctx, query, targetID, userID).Scan(&requests, &follows)
+		if err != nil {
+			return err
+		}
+		if requests+follows == 0 {
+			return ErrNotFound
+		}
+		if follows == 0 {
+			return nil
+		}
+
+		return refan(ctx, tx, targetID)
+	})
+}
+
+// GetProfile returns the user with their follow counts and how they
+// relate to the viewer. Users that blocked each other can not see their
+// profiles.
+func (u *UserStore) GetProfile(ctx context.Context, id, viewerID int64) (*Profile, error) {
+	query := `
+		SELECT ` + userColumns + `,
+			(SELECT COUNT(*) FROM followers WHERE userid = u.id),
+			(SELECT COUNT(*) FROM followers WHERE follower_id = u.id),
+			EXISTS (SELECT 1 FROM followers WHERE userid = $2 AND follower_id = u.id),
+			EXISTS (SELECT 1 FROM followers WHERE userid = u.id AND follower_id = $2),
+			EXISTS (SELECT 1 FROM follow_requests WHERE userid = u.id AND requester_id = $2)
+		FROM users u
+		WHERE id = $1 AND is_active = true AND NOT ` + blocked("u.id", "$2") + `
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var p Profile
+	err := u.db.QueryRowContext(ctx, query, id, viewerID).Scan(
+		&p.ID,
+		&p.Name,
+		&p.DisplayName,
+		&p.Bio,
+		&p.AvatarURL,
+		&p.Password.hash,
+		&p.Email,
+		&p.Age,
+		&p.Gender,
+		&p.Private,
+		&p.Role,
+		&p.DeactivatedAt,
+		&p.FollowerCount,
+		&p.FollowingCount,
+		&p.FollowsYou,
+		&p.YouFollow,
+		&p.Requested,
+	)
 	if err != nil {
-		return err
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
 	}
 
-	return nil
+	return &p, nil
 }
 
-func (u *UserStore) Unfollow(ctx context.Context, targetID, userID int64) error {
+// GetFollowers lists the users following userID, most recent first, as
+// seen by viewerID.
+func (u *UserStore) GetFollowers(ctx context.Context, userID, viewerID int64,
+	after cursor.Key, limit int) ([]FollowEntry, error) {
+	return u.follows(ctx, "f.userid = $1 AND f.follower_id = u.id", userID, viewerID, after, limit)
+}
+
+// GetFollowing lists the users followed by userID, most recent first, as
+// seen by viewerID.
+func (u *UserStore) GetFollowing(ctx context.Context, userID, viewerID int64,
+	after cursor.Key, limit int) ([]FollowEntry, error) {
+	return u.follows(ctx, "f.follower_id = $1 AND f.userid = u.id", userID, viewerID, after, limit)
+}
+
+// follows pages through the followers table by (created_at, id) joined
+// with users on the given condition. The follow graph of an account is
+// seen by its owner and those who may see its posts, it returns
+// ErrNotFound to others and between users that blocked each other as
+// GetProfile does. Users blocking or blocked by viewerID are left out of
+// the listing.
+func (u *UserStore) follows(ctx context.Context, join string, userID, viewerID int64,
+	after cursor.Key, limit int) ([]FollowEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var visible bool
 	query := `
-		DELETE FROM followers
-		WHERE userid = $1 AND follower_id = $2
+		SELECT $1::bigint = $2::bigint OR (COALESCE(` + canSee("$1::bigint", "$2::bigint") + `, false)
+		AND NOT ` + blocked("$1::bigint", "$2::bigint") + `)
+	`
+	if err := u.db.QueryRowContext(ctx, query, userID, viewerID).Scan(&visible); err != nil {
+		return nil, err
+	}
+	if !visible {
+		return nil, ErrNotFound
+	}
+
+	query = `
+		SELECT u.id, u.name, COALESCE(u.display_name, ''), COALESCE(u.bio, ''),
+			COALESCE(u.avatar_url, ''), f.created_at
+		FROM followers f
+		JOIN users u ON ` + join + `
+		WHERE u.is_active = true AND NOT ` + blocked("u.id", "$5") + `
+			AND ($3::bigint = 0 OR (f.created_at, u.id) < ($2::timestamptz, $3))
+		ORDER BY f.created_at DESC, u.id DESC
+		LIMIT $4
 	`
-	_, err := u.db.ExecContext(ctx, query, targetID, userID)
+	rows, err := u.db.QueryContext(ctx, query, userID, after.Time, after.ID, limit, viewerID)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	return nil
+	entries := []FollowEntry{}
+	for rows.Next() {
+		var e FollowEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.Name,
+			&e.DisplayName,
+			&e.Bio,
+			&e.AvatarURL,
+			&e.FollowedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// feedActivity selects the activities, postid and at, in the home feed of
+// viewer. They are read from the precomputed timeline of viewer, only
+// those of followed accounts with more than threshold followers are not
+// fanned out and are pulled here, as are the posts of others tagged with a
+// tag viewer follows. Activities of muted users are left out, as are those
+// not between from and to.
+func feedActivity(viewer, threshold, from, to string) string {
+	return `
+		SELECT t.postid, t.at
+		FROM timelines t
+		JOIN users au ON au.id = t.actor_id AND au.is_active = true
+		WHERE t.userid = ` + viewer + ` AND NOT ` + muted(viewer, "t.actor_id") + `
+		AND t.at BETWEEN ` + from + ` AND ` + to + `
+		UNION ALL
+		SELECT p.id, p.created_at
+		FROM posts p
+		JOIN followers f ON f.userid = p.userid AND f.follower_id = ` + viewer + `
+		JOIN users pu ON pu.id = p.userid AND pu.follower_count > ` + threshold + `
+		WHERE p.status = 'published' AND p.created_at BETWEEN ` + from + ` AND ` + to + `
+		UNION ALL
+		SELECT r.postid, r.created_at
+		FROM reposts r
+		JOIN followers f ON f.userid = r.userid AND f.follower_id = ` + viewer + `
+		JOIN users ru ON ru.id = r.userid AND ru.is_active = true
+		AND ru.follower_count > ` + threshold + `
+		WHERE NOT ` + muted(viewer, "r.userid") + ` AND r.created_at BETWEEN ` + from + ` AND ` + to + `
+		UNION ALL
+		SELECT pt.postid, pt.created_at
+		FROM tag_follows tf
+		JOIN post_tags pt ON pt.tagid = tf.tagid
+		JOIN posts tp ON tp.id = pt.postid AND tp.userid <> ` + viewer + `
+		WHERE tf.userid = ` + viewer + ` AND pt.created_at BETWEEN ` + from + ` AND ` + to + `
+	`
 }
 
 func (u *UserStore) GetFeed(ctx context.Context, userID int64, fq *FilteringQuery) ([]Feed, error) {
+	if fq.Sort == SortTop || fq.Sort == SortRelevant {
+		return u.rankedFeed(ctx, userID, fq)
+	}
+
+	// Every post written or reposted by a followed user, or tagged with a
+	// followed tag, is an activity; a post is placed in the feed by its
+	// most recent activity so that several reposts of it collapse into one
+	// entry. Posts and reposts of muted users are left out.
+	//
+	// Pages are either taken at an offset or, given a cursor, right after
+	// its key in the order of the feed; going back they end right before
+	// the key, they are then fetched in reverse and turned around.
+	//
+	// The key bounds the activities read, so that the indexes on their
+	// times serve the page. Past the key in ascending order the most recent
+	// activity of a post is kept by the bound; in descending order a post
+	// with activity on both sides of the key was placed by the newer one,
+	// on an earlier page, and is left out. Times are kept to the
+	// microsecond, so activity after the key starts a microsecond later.
+	order, op := fq.Sort, "<"
+	if fq.Sort == "asc" {
+		op = ">"
+	}
+	var at time.Time
+	var id int64
+	offset := fq.Offset
+	var from, to any = "-infinity", "infinity"
+	newer := false
+	if fq.Cursor != nil {
+		at, id, offset = fq.Cursor.Time, fq.Cursor.ID, 0
+		if fq.Cursor.Back {
+			order, op = reverse[order], reverse[op]
+		}
+		if op == ">" {
+			from = at
+		} else {
+			to, newer = at, true
+		}
+	}
+
 	query := `
-		SELECT p.id, p.userid, p.title, p.content, p.tags,
-		COUNT(c.id) as comments_count, u.name, p.created_at
-		FROM posts p
-		LEFT JOIN comments c ON c.postid = p.id
+		WITH activity AS (` + feedActivity("$1", "$8", "$9::timestamptz", "$10::timestamptz") + `
+		), entries AS (
+			SELECT postid, MAX(at) AS at
+			FROM activity
+			WHERE NOT ($11::boolean AND postid IN (SELECT postid FROM (` +
+		feedActivity("$1", "$8", "$6::timestamptz + interval '1 microsecond'", "'infinity'") + `
+			) newer))
+			GROUP BY postid
+		)
+		SELECT p.id, p.userid, p.title, p.content, p.format, p.content_html, p.tags,
+		p.comment_count, p.repost_count, u.name, p.created_at, e.at
+		FROM entries e
+		JOIN posts p ON p.id = e.postid
 		LEFT JOIN users u ON u.id = p.userid
-		LEFT JOIN followers f ON f.userid = p.userid AND f.follower_id = $1
-		WHERE f.userid IS NOT NULL AND
-		(p.title ILIKE '%' || $2 || '%' OR p.content ILIKE '%' || $2 || '%') AND
-		(p.tags @> $3 OR $3 = '{}')
-		GROUP BY p.id, u.name
-		ORDER BY p.created_at ` + fq.Sort + `
+		WHERE ($2 = '' OR p.search @@ to_tsquery(p.language, $2)) AND
+		(p.tags @> $3 OR $3 = '{}') AND ` + canSee("p.userid", "$1") + ` AND
+		NOT ` + blocked("p.userid", "$1") + ` AND NOT ` + muted("$1", "p.userid") + ` AND
+		($7::bigint = 0 OR (e.at, p.id) ` + op + ` ($6::timestamptz, $7::bigint))
+		ORDER BY e.at ` + order + `, p.id ` + order + `
 		LIMIT $4 OFFSET $5
 	`
 	rows, err := u.db.QueryContext(
-		ctx, query, userID, fq.Search,
-		pq.Array(fq.Tags), fq.Limit, fq.Offset,
+		ctx, query, userID, tsquery(fq.Search),
+		pq.Array(fq.Tags), fq.Limit, offset, at, id, CelebrityThreshold,
+		from, to, newer,
 	)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
 	var output []Feed
+	var postIDs []int64
 	for rows.Next() {
 		var feed Feed
-		rows.Scan(
+		err := rows.Scan(
 			&feed.Post.ID,
 			&feed.Post.UserID,
 			&feed.Post.Title,
 			&feed.Post.Content,
+			&feed.Post.Format,
+			&feed.Post.ContentHTML,
 			pq.Array(&feed.Post.Tags),
 			&feed.CommentCount,
+			&feed.Post.RepostCount,
 			&feed.Post.User.Name,
 			&feed.Post.CreatedAt,
+			&feed.At,
 		)
+		if err != nil {
+			return nil, err
+		}
 		output = append(output, feed)
+		postIDs = append(postIDs, feed.Post.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(postIDs) == 0 {
+		return output, nil
+	}
+	if fq.Cursor != nil && fq.Cursor.Back {
+		slices.Reverse(output)
 	}
-	return output, nil
 
+	if err := loadAttached(ctx, u.db, feedPosts(output)); err != nil {
+		return nil, err
+	}
+	return output, u.attribute(ctx, userID, output, postIDs)
+}
+
+// attribute lists in each feed entry the reposts of its post made by the
+// accounts that userID follows.
+func (u *UserStore) attribute(ctx context.Context, userID int64, feed []Feed, postIDs []int64) error {
+	reposts := &RepostStore{db: u.db}
+	attribution, err := reposts.followedReposts(ctx, userID, postIDs)
+	if err != nil {
+		return err
+	}
+	for i := range feed {
+		feed[i].RepostedBy = attribution[feed[i].Post.ID]
+	}
+	return nil
 }
 
 func (u *UserStore) CreateAndInvite(ctx context.Context, user *User,
@@ -306,6 +690,9 @@ func (u *UserStore) ActivateUser(ctx context.Context, token string, expiry time.
 	})
 }
 
+// DeleteUser permanently deletes the user, it undoes the creation of an
+// account that could not be invited. Users deleting their own account are
+// deactivated instead.
 func (u *UserStore) DeleteUser(ctx context.Context, user *User) error {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
 	defer cancel()
@@ -324,3 +711,321 @@ func (u *UserStore) DeleteUser(ctx context.Context, user *User) error {
 		return nil
 	})
 }
+
+// DeactivateUser hides the user and their content until the account is
+// either reactivated or purged once the grace period is over.
+func (u *UserStore) DeactivateUser(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET is_active = false, deactivated_at = now()
+		WHERE id = $1 AND is_active = true
+		RETURNING deactivated_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	err := u.db.QueryRowContext(ctx, query, user.ID).Scan(&user.DeactivatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+	user.Active = false
+	return nil
+}
+
+// ReactivateUser cancels the deletion of an account deactivated after
+// since. It returns ErrNotFound once the grace period is over.
+func (u *UserStore) ReactivateUser(ctx context.Context, user *User, since time.Time) error {
+	query := `
+		UPDATE users
+		SET is_active = true, deactivated_at = NULL
+		WHERE id = $1 AND deactivated_at > $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := u.db.ExecContext(ctx, query, user.ID, since)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	user.Active = true
+	user.DeactivatedAt = nil
+	return nil
+}
+
+// SuspendUser hides targetID from search, explore, suggestions, tags and
+// syndication feeds until the suspension is lifted. Only users of a lower
+// role than role can be suspended, ErrNotFound is returned otherwise. A
+// suspended user keeps the time they were first suspended at.
+func (u *UserStore) SuspendUser(ctx context.Context, targetID int64, role int) error {
+	query := `
+		UPDATE users
+		SET suspended_at = COALESCE(suspended_at, now())
+		WHERE id = $1 AND role < $2
+	`
+	return u.updateSuspension(ctx, query, targetID, role)
+}
+
+// UnsuspendUser lifts the suspension of targetID, under the same rule as
+// SuspendUser.
+func (u *UserStore) UnsuspendUser(ctx context.Context, targetID int64, role int) error {
+	query := `
+		UPDATE users
+		SET suspended_at = NULL
+		WHERE id = $1 AND role < $2
+	`
+	return u.updateSuspension(ctx, query, targetID, role)
+}
+
+func (u *UserStore) updateSuspension(ctx context.Context, query string, targetID int64, role int) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := u.db.ExecContext(ctx, query, targetID, role)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PurgeDeactivated permanently deletes the accounts deactivated before
+// before, with everything they own, and returns them along with the paths
+// of their export archives, which are left to be removed. It does nothing
+// and returns ErrLocked when another instance is already purging.
+func (u *UserStore) PurgeDeactivated(ctx context.Context, before time.Time) ([]User, []string, error) {
+	var purged []User
+	var archives []string
+	err := withTx(u.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+		defer cancel()
+
+		err := tryLock(ctx, tx, lockPurge)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			DELETE FROM data_exports
+			WHERE path IS NOT NULL
+			AND userid IN (SELECT id FROM users WHERE deactivated_at < $1)
+			RETURNING path
+		`
+		rows, err := tx.QueryContext(ctx, query, before)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var path string
+			if err := rows.Scan(&path); err != nil {
+				return err
+			}
+			archives = append(archives, path)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		query = `
+			DELETE FROM users
+			WHERE deactivated_at < $1
+			RETURNING id, name, email
+		`
+		rows, err = tx.QueryContext(ctx, query, before)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var user User
+			if err := rows.Scan(&user.ID, &user.Name, &user.Email); err != nil {
+				return err
+			}
+			purged = append(purged, user)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return purged, archives, nil
+}
+
+// UpdateUser saves the profile of user. An account that is made public
+// accepts the follow requests it had pending.
+func (u *UserStore) UpdateUser(ctx context.Context, user *User) error {
+	return withTx(u.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE users
+			SET name = $2, display_name = NULLIF($3, ''), bio = NULLIF($4, ''),
+			avatar_url = NULLIF($5, ''), age = $6, gender = $7::int::boolean, is_private = $8
+			WHERE id = $1
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+		defer cancel()
+
+		_, err := tx.ExecContext(ctx, query,
+			user.ID,
+			user.Name,
+			user.DisplayName,
+			user.Bio,
+			user.AvatarURL,
+			user.Age,
+			user.Gender,
+			user.Private,
+		)
+		if err != nil {
+			return uniqueUserErr(err)
+		}
+		if user.Private {
+			return nil
+		}
+
+		query = `
+			WITH accepted AS (
+				DELETE FROM follow_requests
+				WHERE userid = $1
+				RETURNING userid, requester_id
+			)
+			INSERT INTO followers (userid, follower_id)
+			SELECT userid, requester_id FROM accepted
+			ON CONFLICT DO NOTHING
+			RETURNING follower_id
+		`
+		rows, err := tx.QueryContext(ctx, query, user.ID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var followers []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			followers = append(followers, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		for _, id := range followers {
+			if err := backfill(ctx, tx, id, user.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (u *UserStore) UpdatePassword(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET password = $2
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	_, err := u.db.ExecContext(ctx, query, user.ID, user.Password.hash)
+	return err
+}
+
+// RequestEmailChange stores the hashed token that confirms the move of a
+// user to a new email address, replacing any pending request.
+func (u *UserStore) RequestEmailChange(ctx context.Context, userID int64, email,
+	token string, expiry time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(u.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)
+		`
+		var taken bool
+		if err := tx.QueryRowContext(ctx, query, email).Scan(&taken); err != nil {
+			return err
+		}
+		if taken {
+			return ErrDupliMail
+		}
+
+		query = `
+			DELETE FROM email_tokens
+			WHERE userid = $1
+		`
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO email_tokens (userid, token, email, expiry)
+			VALUES ($1, $2, $3, $4)
+		`
+		_, err := tx.ExecContext(ctx, query, userID, token, email, time.Now().Add(expiry))
+		return err
+	})
+}
+
+// ConfirmEmailChange switches the email of the user the token was issued
+// to, as long as it has not expired.
+func (u *UserStore) ConfirmEmailChange(ctx context.Context, token string, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(u.db, ctx, func(tx *sql.Tx) error {
+		hashtoken := sha256.Sum256([]byte(token))
+		hash := hex.EncodeToString(hashtoken[:])
+
+		query := `
+			DELETE FROM email_tokens
+			WHERE token = $1
+			RETURNING userid, email, expiry > $2
+		`
+		var userID int64
+		var email string
+		var valid bool
+		err := tx.QueryRowContext(ctx, query, hash, now).Scan(&userID, &email, &valid)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrTokenExpired
+			default:
+				return err
+			}
+		}
+		if !valid {
+			return ErrTokenExpired
+		}
+
+		query = `
+			UPDATE users
+			SET email = $2
+			WHERE id = $1
+		`
+		if _, err = tx.ExecContext(ctx, query, userID, email); err != nil {
+			return uniqueUserErr(err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,149 @@
+package entities
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Mention is an @username token found in a piece of text.
+// Start and End are rune offsets into the text, End is exclusive.
+type Mention struct {
+	Username string `json:"username"`
+	UserID   int64  `json:"userid,omitempty"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+// Hashtag is a #tag token found in a piece of text.
+// Start and End are rune offsets into the text, End is exclusive.
+type Hashtag struct {
+	Tag   string `json:"tag"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Entities holds every mention and hashtag of a text so that clients can
+// render them as links without parsing the content themselves.
+type Entities struct {
+	Mentions []Mention `json:"mentions,omitempty"`
+	Hashtags []Hashtag `json:"hashtags,omitempty"`
+}
+
+func isTokenRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// MaxTagLen is the length in runes a tag is cut to.
+const MaxTagLen = 64
+
+// Slug returns the canonical form of a tag: lowercase, without the leading
+// # and made of letters, digits and underscores only. It returns "" when
+// nothing of tag is left or when it has no letter.
+func Slug(tag string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#")) {
+		if !isTokenRune(r) {
+			continue
+		}
+		if n == MaxTagLen {
+			break
+		}
+		b.WriteRune(r)
+		n++
+	}
+	slug := b.String()
+	if !strings.ContainsFunc(slug, unicode.IsLetter) {
+		return ""
+	}
+	return slug
+}
+
+// Parse extracts the mentions and hashtags of text. A token has to start
+// the text or follow a rune that can not be part of a token, so that
+// e-mail addresses and anchors inside words are not picked up.
+func Parse(text string) Entities {
+	var ents Entities
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); i++ {
+		sigil := runes[i]
+		if sigil != '@' && sigil != '#' {
+			continue
+		}
+		if i > 0 && (isTokenRune(runes[i-1]) || runes[i-1] == '@' || runes[i-1] == '#') {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && isTokenRune(runes[end]) {
+			end++
+		}
+		if end == i+1 {
+			continue
+		}
+		token := string(runes[i+1 : end])
+
+		switch sigil {
+		case '@':
+			ents.Mentions = append(ents.Mentions, Mention{
+				Username: token,
+				Start:    i,
+				End:      end,
+			})
+		case '#':
+			// a tag made of digits only is most likely a number ("#1")
+			if !strings.ContainsFunc(token, unicode.IsLetter) {
+				continue
+			}
+			ents.Hashtags = append(ents.Hashtags, Hashtag{
+				Tag:   strings.ToLower(token),
+				Start: i,
+				End:   end,
+			})
+		}
+		i = end - 1
+	}
+
+	return ents
+}
+
+// Usernames returns the distinct usernames mentioned.
+func (e *Entities) Usernames() []string {
+	seen := make(map[string]bool)
+	var output []string
+	for _, m := range e.Mentions {
+		if !seen[m.Username] {
+			seen[m.Username] = true
+			output = append(output, m.Username)
+		}
+	}
+	return output
+}
+
+// Tags returns the distinct hashtags used.
+func (e *Entities) Tags() []string {
+	seen := make(map[string]bool)
+	var output []string
+	for _, h := range e.Hashtags {
+		if !seen[h.Tag] {
+			seen[h.Tag] = true
+			output = append(output, h.Tag)
+		}
+	}
+	return output
+}
+
+// Resolve sets the user id of every mention whose username is in ids and
+// drops the mentions that did not match an existing user.
+func (e *Entities) Resolve(ids map[string]int64) {
+	mentions := e.Mentions[:0]
+	for _, m := range e.Mentions {
+		id, ok := ids[m.Username]
+		if !ok {
+			continue
+		}
+		m.UserID = id
+		mentions = append(mentions, m)
+	}
+	e.Mentions = mentions
+}
@@ -0,0 +1,116 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want Entities
+	}{
+		{
+			name: "mention and hashtag",
+			text: "hi @ada see #golang",
+			want: Entities{
+				Mentions: []Mention{{Username: "ada", Start: 3, End: 7}},
+				Hashtags: []Hashtag{{Tag: "golang", Start: 12, End: 19}},
+			},
+		},
+		{
+			name: "offsets count runes",
+			text: "héllo wörld 🎉 @zoë #café",
+			want: Entities{
+				Mentions: []Mention{{Username: "zoë", Start: 14, End: 18}},
+				Hashtags: []Hashtag{{Tag: "café", Start: 19, End: 24}},
+			},
+		},
+		{
+			name: "email address",
+			text: "write to a@b.com",
+			want: Entities{},
+		},
+		{
+			name: "doubled sigil",
+			text: "##x and @@ada",
+			want: Entities{},
+		},
+		{
+			name: "digits only hashtag",
+			text: "issue #123 is #2fast",
+			want: Entities{
+				Hashtags: []Hashtag{{Tag: "2fast", Start: 14, End: 20}},
+			},
+		},
+		{
+			name: "hashtag lowercased",
+			text: "#GoLang",
+			want: Entities{
+				Hashtags: []Hashtag{{Tag: "golang", Start: 0, End: 7}},
+			},
+		},
+		{
+			name: "duplicate tokens keep every occurrence",
+			text: "@ada #go @ada #go",
+			want: Entities{
+				Mentions: []Mention{
+					{Username: "ada", Start: 0, End: 4},
+					{Username: "ada", Start: 9, End: 13},
+				},
+				Hashtags: []Hashtag{
+					{Tag: "go", Start: 5, End: 8},
+					{Tag: "go", Start: 14, End: 17},
+				},
+			},
+		},
+		{
+			name: "punctuation ends a token",
+			text: "(@ada), #go!",
+			want: Entities{
+				Mentions: []Mention{{Username: "ada", Start: 1, End: 5}},
+				Hashtags: []Hashtag{{Tag: "go", Start: 8, End: 11}},
+			},
+		},
+		{
+			name: "lone sigils",
+			text: "@ # @",
+			want: Entities{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDistinct(t *testing.T) {
+	ents := Parse("@ada #go @bob @ada #Go #rust")
+
+	if got, want := ents.Usernames(), []string{"ada", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Usernames = %v, want %v", got, want)
+	}
+	if got, want := ents.Tags(), []string{"go", "rust"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags = %v, want %v", got, want)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	ents := Parse("@ada @ghost @bob @ada")
+	ents.Resolve(map[string]int64{"ada": 1, "bob": 2})
+
+	want := []Mention{
+		{Username: "ada", UserID: 1, Start: 0, End: 4},
+		{Username: "bob", UserID: 2, Start: 12, End: 16},
+		{Username: "ada", UserID: 1, Start: 17, End: 21},
+	}
+	if !reflect.DeepEqual(ents.Mentions, want) {
+		t.Errorf("Mentions = %+v, want %+v", ents.Mentions, want)
+	}
+}
@@ -24,3 +24,15 @@ func GetString(key, fallback string) string {
 	}
 	return val
 }
+
+func GetFloat(key string, fallback float64) float64 {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	valf, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return fallback
+	}
+	return valf
+}
@@ -0,0 +1,51 @@
+package markup
+
+import (
+	"bytes"
+	"html"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Formats of the content of a post.
+const (
+	Plain    = "plain"
+	Markdown = "markdown"
+)
+
+// Raw HTML of the markdown is dropped by the renderer already, the policy
+// then cleans whatever markdown can still express: links and images are
+// limited to web and mail addresses and links are marked nofollow.
+var (
+	renderer = goldmark.New(
+		goldmark.WithExtensions(extension.Strikethrough, extension.Table, extension.Linkify),
+	)
+	policy = newPolicy()
+)
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireNoFollowOnLinks(true)
+	p.RequireNoReferrerOnFullyQualifiedLinks(true)
+	return p
+}
+
+// Render returns content as sanitized HTML. Markdown is rendered, plain
+// text is escaped with its line breaks kept.
+func Render(format, content string) (string, error) {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if format != Markdown {
+		// matches the rendering of existing posts by the migration
+		return "<p>" + strings.ReplaceAll(html.EscapeString(content), "\n", "<br>") + "</p>", nil
+	}
+
+	var buf bytes.Buffer
+	if err := renderer.Convert([]byte(content), &buf); err != nil {
+		return "", err
+	}
+	return policy.Sanitize(buf.String()), nil
+}
@@ -0,0 +1,131 @@
+package markup
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// checkSafe fails t when the HTML got holds an element or attribute that
+// can run script, or a link to anything but a web or mail address.
+func checkSafe(t *testing.T, got string) {
+	t.Helper()
+	z := html.NewTokenizer(strings.NewReader(got))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tok := z.Token()
+		switch tok.Data {
+		case "script", "svg", "iframe", "object", "embed", "style", "math":
+			t.Errorf("%q: unsafe element <%s>", got, tok.Data)
+		}
+		for _, attr := range tok.Attr {
+			if strings.HasPrefix(attr.Key, "on") || attr.Key == "style" {
+				t.Errorf("%q: unsafe attribute %s on <%s>", got, attr.Key, tok.Data)
+			}
+			if attr.Key != "href" && attr.Key != "src" {
+				continue
+			}
+			u, err := url.Parse(attr.Val)
+			if err != nil {
+				t.Errorf("%q: invalid %s %q", got, attr.Key, attr.Val)
+				continue
+			}
+			switch strings.ToLower(u.Scheme) {
+			case "", "http", "https", "mailto":
+			default:
+				t.Errorf("%q: %s with scheme %q", got, attr.Key, u.Scheme)
+			}
+		}
+	}
+}
+
+func TestRenderSanitizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"script", `<script>alert(1)</script>`},
+		{"img onerror", `<img src=x onerror="alert(1)">`},
+		{"svg onload", `<svg onload="alert(1)"></svg>`},
+		{"javascript link", `<a href="javascript:alert(1)">x</a>`},
+		{"mixed case scheme", `<a href="JaVaScRiPt:alert(1)">x</a>`},
+		{"data link", `<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`},
+		{"decimal entities", `<a href="&#106;&#97;&#118;&#97;&#115;&#99;&#114;&#105;&#112;&#116;&#58;alert(1)">x</a>`},
+		{"hex entities", `<a href="&#x6A;avascript&#x3A;alert(1)">x</a>`},
+		{"markdown javascript link", `[x](javascript:alert(1))`},
+		{"markdown mixed case link", `[x](JaVaScRiPt:alert(1))`},
+		{"markdown entity link", `[x](&#106;avascript:alert(1))`},
+		{"markdown data link", `[x](data:text/html;base64,PHNjcmlwdD4=)`},
+		{"markdown data image", `![x](data:image/svg+xml;base64,PHN2ZyBvbmxvYWQ9YWxlcnQoMSk+)`},
+		{"markdown javascript reference", "[x][1]\n\n[1]: javascript:alert(1)"},
+	}
+
+	for _, tt := range tests {
+		for _, format := range []string{Plain, Markdown} {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				got, err := Render(format, tt.content)
+				if err != nil {
+					t.Fatalf("Render: %v", err)
+				}
+				checkSafe(t, got)
+			})
+		}
+	}
+}
+
+func TestRenderLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		href    string
+	}{
+		{"inline", `[site](https://example.com/a)`, `href="https://example.com/a"`},
+		{"autolink", `see https://example.com/b`, `href="https://example.com/b"`},
+		{"mail", `[mail](mailto:ada@example.com)`, `href="mailto:ada@example.com"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Render(Markdown, tt.content)
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			if !strings.Contains(got, tt.href) {
+				t.Errorf("Render(%q) = %q, want link %s", tt.content, got, tt.href)
+			}
+			if !strings.Contains(got, `rel="nofollow`) {
+				t.Errorf("Render(%q) = %q, want rel=\"nofollow\"", tt.content, got)
+			}
+		})
+	}
+}
+
+func TestRenderPlain(t *testing.T) {
+	got, err := Render(Plain, "*not* markdown\r\n<b>bold</b> & more")
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	want := "<p>*not* markdown<br>&lt;b&gt;bold&lt;/b&gt; &amp; more</p>"
+	if got != want {
+		t.Errorf("Render = %q, want %q", got, want)
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	got, err := Render(Markdown, "**bold** ~~gone~~")
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	want := "<p><strong>bold</strong> <del>gone</del></p>\n"
+	if got != want {
+		t.Errorf("Render = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package media
+
+import (
+	"image"
+	"math"
+	"strings"
+)
+
+// Blurhash components, along x and y, of the placeholders.
+const (
+	blurhashX = 4
+	blurhashY = 3
+)
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Blurhash encodes img into a short string clients decode into a blurred
+// placeholder while the image loads, see https://blurha.sh. img should be
+// small, every pixel is visited for every component.
+func Blurhash(img image.Image) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	var factors [blurhashX * blurhashY][3]float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			pixel := [3]float64{toLinear(r >> 8), toLinear(g >> 8), toLinear(bl >> 8)}
+			for j := range blurhashY {
+				for i := range blurhashX {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(w)) *
+						math.Cos(math.Pi*float64(j*y)/float64(h))
+					f := &factors[j*blurhashX+i]
+					for c := range 3 {
+						f[c] += basis * pixel[c]
+					}
+				}
+			}
+		}
+	}
+	for k := range factors {
+		norm := 2.0
+		if k == 0 {
+			norm = 1
+		}
+		for c := range 3 {
+			factors[k][c] *= norm / float64(w*h)
+		}
+	}
+
+	var sb strings.Builder
+	encode83(&sb, (blurhashX-1)+(blurhashY-1)*9, 1)
+
+	maxAC := 0.0
+	for _, f := range factors[1:] {
+		for _, v := range f {
+			maxAC = math.Max(maxAC, math.Abs(v))
+		}
+	}
+	quantMax := int(math.Max(0, math.Min(82, math.Floor(maxAC*166-0.5))))
+	maxValue := float64(quantMax+1) / 166
+	encode83(&sb, quantMax, 1)
+
+	dc := factors[0]
+	encode83(&sb, toSRGB(dc[0])<<16|toSRGB(dc[1])<<8|toSRGB(dc[2]), 4)
+	for _, f := range factors[1:] {
+		value := 0
+		for _, v := range f {
+			q := math.Floor(signPow(v/maxValue, 0.5)*9 + 9.5)
+			value = value*19 + int(math.Max(0, math.Min(18, q)))
+		}
+		encode83(&sb, value, 2)
+	}
+	return sb.String()
+}
+
+func encode83(sb *strings.Builder, value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		digit := value / int(math.Pow(83, float64(i))) % 83
+		sb.WriteByte(base83[digit])
+	}
+}
+
+func toLinear(v uint32) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func toSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
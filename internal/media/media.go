@@ -0,0 +1,96 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Media kinds, a post carries images and videos.
+const (
+	Image = "image"
+	Video = "video"
+)
+
+// Upload limits, in bytes.
+const (
+	MaxImageSize = 10 << 20
+	MaxVideoSize = 50 << 20
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported media type")
+	ErrTooLarge    = errors.New("media is too large")
+	ErrMalformed   = errors.New("malformed media")
+)
+
+// types are the accepted MIME types and their file extensions.
+var types = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"video/mp4":  ".mp4",
+	"video/webm": ".webm",
+}
+
+// Sniff returns the MIME type of the upload starting with head, judged
+// from its content rather than from what the client claims. It returns
+// ErrUnsupported for anything but the accepted images and videos.
+func Sniff(head []byte) (string, error) {
+	mime := http.DetectContentType(head)
+	if _, ok := types[mime]; !ok {
+		return "", ErrUnsupported
+	}
+	return mime, nil
+}
+
+// Kind returns whether mime is an image or a video.
+func Kind(mime string) string {
+	kind, _, _ := strings.Cut(mime, "/")
+	return kind
+}
+
+// Ext returns the file extension of an accepted MIME type.
+func Ext(mime string) string {
+	return types[mime]
+}
+
+// MaxSize returns the largest accepted upload of type mime.
+func MaxSize(mime string) int64 {
+	if Kind(mime) == Video {
+		return MaxVideoSize
+	}
+	return MaxImageSize
+}
+
+// Read reads an upload from r, up to the size limit of its type. It
+// returns the upload and its sniffed MIME type, or ErrUnsupported and
+// ErrTooLarge.
+func Read(r io.Reader) ([]byte, string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return nil, "", ErrMalformed
+		}
+		return nil, "", err
+	}
+	head = head[:n]
+	mime, err := Sniff(head)
+	if err != nil {
+		return nil, "", err
+	}
+
+	max := MaxSize(mime)
+	data, err := io.ReadAll(io.LimitReader(io.MultiReader(bytes.NewReader(head), r), max+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > max {
+		return nil, "", ErrTooLarge
+	}
+	return data, mime, nil
+}
@@ -0,0 +1,161 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Strip removes the metadata of an upload of type mime, where cameras and
+// editors record locations, devices and names: Exif, XMP and IPTC blocks
+// and text chunks of images, user data and tags of videos. The pixels are
+// left untouched, so the orientation Exif gave is lost. GIF carries no
+// such metadata.
+func Strip(mime string, data []byte) ([]byte, error) {
+	switch mime {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	case "video/mp4":
+		return stripMP4(data)
+	case "video/webm":
+		return stripWebM(data)
+	}
+	return data, nil
+}
+
+// stripJPEG drops the APP1 (Exif, XMP), APP13 (IPTC) and comment segments
+// of a JPEG, the ICC profile of APP2 is kept for the colours to render.
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, ErrMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	i := 2
+	for {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, ErrMalformed
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// fill byte
+			i++
+			continue
+		}
+		if marker == 0xD8 || marker == 0x01 || marker >= 0xD0 && marker <= 0xD7 {
+			// markers without a payload
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + size
+		if size < 2 || end > len(data) {
+			return nil, ErrMalformed
+		}
+		if marker == 0xDA {
+			// the scan runs to the end of the image, nothing follows that
+			// needs to be removed
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+		if marker != 0xE1 && marker != 0xED && marker != 0xFE {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadata are the chunks dropped from a PNG.
+var pngMetadata = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, ErrMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+
+	i := len(pngSignature)
+	for {
+		// length, type, data and CRC
+		if i+12 > len(data) {
+			return nil, ErrMalformed
+		}
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + size
+		if size < 0 || end > len(data) {
+			return nil, ErrMalformed
+		}
+		typ := string(data[i+4 : i+8])
+		if !pngMetadata[typ] {
+			out.Write(data[i:end])
+		}
+		if typ == "IEND" {
+			return out.Bytes(), nil
+		}
+		i = end
+	}
+}
+
+// VP8X flags telling which metadata chunks follow.
+const (
+	webpXMP  = 0x04
+	webpEXIF = 0x08
+)
+
+// stripWebP drops the EXIF and XMP chunks of a WebP and their flags from
+// its VP8X header.
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrMalformed
+	}
+	riff := int(binary.LittleEndian.Uint32(data[4:]))
+	if riff < 4 || 8+riff > len(data) {
+		return nil, ErrMalformed
+	}
+	data = data[:8+riff]
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, ErrMalformed
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		// chunks are padded to an even size
+		end := i + 8 + size + size&1
+		if end > len(data) {
+			return nil, ErrMalformed
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := out.Len()
+			out.Write(data[i:end])
+			if size > 0 {
+				out.Bytes()[start+8] &^= webpEXIF | webpXMP
+			}
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+	return stripped, nil
+}
@@ -0,0 +1,316 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage is a small image with enough detail for its encoding to carry
+// real pixel data.
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := range 16 {
+		for x := range 16 {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+	return img
+}
+
+func jpegSegment(marker byte, payload string) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+func pngChunk(typ, data string) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func riffChunk(fourcc, data string) []byte {
+	chunk := append([]byte(fourcc), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func riff(chunks ...[]byte) []byte {
+	body := bytes.Join(chunks, nil)
+	out := []byte("RIFF\x00\x00\x00\x00WEBP")
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(body)+4))
+	return append(out, body...)
+}
+
+func box(typ string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(body)+8))
+	out = append(out, typ...)
+	return append(out, body...)
+}
+
+// ebml encodes an element whose id is given with its marker, sizes are
+// written on 8 bytes as muxers often do.
+func ebml(id []byte, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	size := binary.BigEndian.AppendUint64(nil, uint64(len(body)))
+	size[0] = 0x01
+	out := append(bytes.Clone(id), size...)
+	return append(out, body...)
+}
+
+func TestStripJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	icc := jpegSegment(0xE2, "ICC_PROFILE\x00\x01\x01profile")
+
+	data := bytes.Join([][]byte{
+		plain[:2],
+		jpegSegment(0xE1, "Exif\x00\x00GPS 51.5N 0.1W"),
+		icc,
+		jpegSegment(0xE1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"),
+		jpegSegment(0xED, "Photoshop 3.0\x00IPTC"),
+		jpegSegment(0xFE, "taken by ada"),
+		plain[2:],
+	}, nil)
+
+	got, err := Strip("image/jpeg", data)
+	if err != nil {
+		t.Fatalf("Strip: %v", err)
+	}
+	want := bytes.Join([][]byte{plain[:2], icc, plain[2:]}, nil)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Strip kept %d bytes, want the %d of the image and its ICC profile", len(got), len(want))
+	}
+	for _, leak := range []string{"Exif", "GPS", "xmpmeta", "IPTC", "ada"} {
+		if bytes.Contains(got, []byte(leak)) {
+			t.Errorf("Strip left %q", leak)
+		}
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+		t.Errorf("decode stripped image: %v", err)
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	// the signature and IHDR come first, 8 and 25 bytes long
+	head, rest := plain[:33], plain[33:]
+	icc := pngChunk("iCCP", "sRGB\x00\x00profile")
+
+	data := bytes.Join([][]byte{
+		head,
+		pngChunk("eXIf", "MM\x00*GPS 51.5N 0.1W"),
+		icc,
+		pngChunk("tEXt", "Author\x00ada"),
+		pngChunk("iTXt", "XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"),
+		pngChunk("tIME", "\x07\xe8\x05\x01\x0c\x1e\x00"),
+		rest,
+	}, nil)
+
+	got, err := Strip("image/png", data)
+	if err != nil {
+		t.Fatalf("Strip: %v", err)
+	}
+	want := bytes.Join([][]byte{head, icc, rest}, nil)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Strip kept %d bytes, want the %d of the image and its ICC profile", len(got), len(want))
+	}
+	decoded, err := png.Decode(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("decode stripped image: %v", err)
+	}
+	if c := decoded.At(3, 5); c != (color.RGBA{48, 80, 128, 255}) {
+		t.Errorf("pixel (3, 5) = %v after Strip", c)
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	const iccFlag = 0x20
+	// flags, reserved bytes and the canvas size
+	vp8x := []byte{iccFlag | webpEXIF | webpXMP, 0, 0, 0, 0x0f, 0, 0, 0x0f, 0, 0}
+	// an odd size, so the chunk is padded
+	pixels := riffChunk("VP8L", "\x2f\x0f\xc0\x0f\x00pixels")
+	icc := riffChunk("ICCP", "profile")
+
+	data := riff(
+		riffChunk("VP8X", string(vp8x)),
+		icc,
+		pixels,
+		riffChunk("EXIF", "MM\x00*GPS 51.5N 0.1W"),
+		riffChunk("XMP ", "<x:xmpmeta/>"),
+	)
+
+	got, err := Strip("image/webp", data)
+	if err != nil {
+		t.Fatalf("Strip: %v", err)
+	}
+	vp8x[0] = iccFlag
+	want := riff(riffChunk("VP8X", string(vp8x)), icc, pixels)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Strip = %q, want %q", got, want)
+	}
+}
+
+func TestStripMP4(t *testing.T) {
+	samples := box("mdat", []byte("samples of the video"))
+	data := bytes.Join([][]byte{
+		box("ftyp", []byte("isom\x00\x00\x02\x00isom")),
+		box("moov",
+			box("mvhd", make([]byte, 12)),
+			box("udta", box("\xa9xyz", []byte("+51.5-000.1/"))),
+			box("trak",
+				box("tkhd", make([]byte, 12)),
+				box("meta", box("hdlr", []byte("mdirappl")), box("ilst", []byte("iPhone 15"))),
+			),
+		),
+		samples,
+	}, nil)
+
+	got, err := Strip("video/mp4", data)
+	if err != nil {
+		t.Fatalf("Strip: %v", err)
+	}
+	if len(got) != len(data) {
+		t.Fatalf("Strip changed the size from %d to %d, offsets of samples moved", len(data), len(got))
+	}
+	for _, leak := range []string{"udta", "\xa9xyz", "+51.5", "meta", "iPhone"} {
+		if bytes.Contains(got, []byte(leak)) {
+			t.Errorf("Strip left %q", leak)
+		}
+	}
+	if !bytes.HasSuffix(got, samples) {
+		t.Error("Strip changed the samples")
+	}
+	for _, kept := range []string{"ftyp", "moov", "mvhd", "trak", "tkhd", "free"} {
+		if !bytes.Contains(got, []byte(kept)) {
+			t.Errorf("Strip dropped %q", kept)
+		}
+	}
+}
+
+func TestStripWebM(t *testing.T) {
+	cluster := ebml([]byte{0x1F, 0x43, 0xB6, 0x75}, []byte("frames"))
+	scale := ebml([]byte{0x2A, 0xD7, 0xB1}, []byte{0x0F, 0x42, 0x40})
+	data := bytes.Join([][]byte{
+		ebml([]byte{0x1A, 0x45, 0xDF, 0xA3}, ebml([]byte{0x42, 0x82}, []byte("webm"))),
+		ebml([]byte{0x18, 0x53, 0x80, 0x67},
+			ebml([]byte{0x15, 0x49, 0xA9, 0x66},
+				scale,
+				ebml([]byte{0x7B, 0xA9}, []byte("holiday at ada's")),
+				ebml([]byte{0x4D, 0x80}, []byte("Lavf60")),
+			),
+			ebml([]byte{0x12, 0x54, 0xC3, 0x67}, []byte("location tags")),
+			cluster,
+		),
+	}, nil)
+
+	got, err := Strip("video/webm", data)
+	if err != nil {
+		t.Fatalf("Strip: %v", err)
+	}
+	if len(got) != len(data) {
+		t.Fatalf("Strip changed the size from %d to %d", len(data), len(got))
+	}
+	for _, leak := range []string{"holiday", "Lavf60", "location"} {
+		if bytes.Contains(got, []byte(leak)) {
+			t.Errorf("Strip left %q", leak)
+		}
+	}
+	if !bytes.Contains(got, scale) || !bytes.HasSuffix(got, cluster) {
+		t.Error("Strip changed the timecode scale or the frames")
+	}
+}
+
+func TestStripMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	plainJPEG := bytes.Clone(buf.Bytes())
+	buf.Reset()
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	plainPNG := buf.Bytes()
+
+	oversizedChunk := pngChunk("tEXt", "x")
+	binary.BigEndian.PutUint32(oversizedChunk, 0xFFFFFFFF)
+	oversizedRIFF := riff(riffChunk("VP8L", "pixels"))
+	binary.LittleEndian.PutUint32(oversizedRIFF[4:], 0xFFFFFFFF)
+	oversizedWebPChunk := riff(riffChunk("VP8L", "pixels"))
+	binary.LittleEndian.PutUint32(oversizedWebPChunk[16:], 0xFFFFFFF0)
+	ftyp := box("ftyp", []byte("isom"))
+
+	tests := []struct {
+		name string
+		mime string
+		data []byte
+	}{
+		{"empty jpeg", "image/jpeg", nil},
+		{"jpeg without SOI", "image/jpeg", []byte("not a jpeg")},
+		{"truncated jpeg", "image/jpeg", plainJPEG[:20]},
+		{"jpeg segment past the end", "image/jpeg", append([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF}, "Exif"...)},
+		{"jpeg segment length below its own", "image/jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0x00}},
+		{"jpeg without scan", "image/jpeg", plainJPEG[:2]},
+		{"png without signature", "image/png", []byte("GIF89a")},
+		{"truncated png", "image/png", plainPNG[:len(plainPNG)-6]},
+		{"png chunk past the end", "image/png", append(bytes.Clone(plainPNG[:33]), oversizedChunk...)},
+		{"png without IEND", "image/png", plainPNG[:33]},
+		{"webp without header", "image/webp", []byte("RIFF")},
+		{"webp size past the end", "image/webp", oversizedRIFF},
+		{"webp chunk past the end", "image/webp", oversizedWebPChunk},
+		{"webp truncated chunk header", "image/webp", riff([]byte("VP8"))},
+		{"mp4 without ftyp", "video/mp4", []byte("\x00\x00\x00\x08moov")},
+		{"mp4 box past the end", "video/mp4", append(bytes.Clone(ftyp), 0x7F, 0xFF, 0xFF, 0xFF, 'm', 'o', 'o', 'v')},
+		{"mp4 box shorter than its header", "video/mp4", append(bytes.Clone(ftyp), 0, 0, 0, 4, 'm', 'o', 'o', 'v')},
+		{"mp4 large size past the end", "video/mp4", append(bytes.Clone(ftyp),
+			0, 0, 0, 1, 'm', 'd', 'a', 't', 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)},
+		{"mp4 truncated large size", "video/mp4", append(bytes.Clone(ftyp), 0, 0, 0, 1, 'm', 'd', 'a', 't', 0)},
+		{"mp4 child past its parent", "video/mp4", append(bytes.Clone(ftyp),
+			box("moov", []byte{0, 0, 0, 0x40, 'u', 'd', 't', 'a'})...)},
+		{"webm without header", "video/webm", []byte("webm")},
+		{"webm element past the end", "video/webm", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x88, 'w'}},
+		{"webm truncated size", "video/webm", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x01, 0x00}},
+		{"webm unknown size of a header", "video/webm", []byte{0x1A, 0x45, 0xDF, 0xA3, 0xFF}},
+		{"webm child past its parent", "video/webm", []byte{
+			0x1A, 0x45, 0xDF, 0xA3, 0x80,
+			0x18, 0x53, 0x80, 0x67, 0x83, 0x7B, 0xA9, 0x85,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Strip(tt.mime, tt.data); !errors.Is(err, ErrMalformed) {
+				t.Errorf("Strip = %v, want %v", err, ErrMalformed)
+			}
+		})
+	}
+}
+
+func TestStripLeavesGIF(t *testing.T) {
+	data := []byte("GIF89a\x01\x00\x01\x00")
+	got, err := Strip("image/gif", data)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Strip = %q, %v, want the GIF unchanged", got, err)
+	}
+}
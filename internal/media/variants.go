@@ -0,0 +1,112 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+
+	// decoders of the accepted image types
+	_ "image/gif"
+	_ "image/png"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// VariantMIME is the type of every variant, whatever the type of the
+// original: variants are re-encoded as baseline JPEG.
+const VariantMIME = "image/jpeg"
+
+// MaxPixels is the largest image variants are made of, bigger images are
+// refused before being decoded.
+const MaxPixels = 50_000_000
+
+// Sizes of the variants, as the longest edge in pixels. Images are never
+// enlarged, the variants of a small image may have the same size.
+var Sizes = []struct {
+	Name string
+	Edge int
+}{
+	{"full", 2048},
+	{"medium", 1080},
+	{"thumb", 320},
+}
+
+// Variant is a resized copy of an image.
+type Variant struct {
+	Name   string
+	Data   []byte
+	Width  int
+	Height int
+}
+
+// Processed is an image turned into its variants.
+type Processed struct {
+	Width    int
+	Height   int
+	Blurhash string
+	Variants []Variant
+}
+
+// Process decodes an image and resizes it into each of Sizes. Only the
+// first frame of a GIF is kept, and transparent pixels are laid over
+// white. Decoding and re-encoding drops anything but the pixels, the
+// variants carry no metadata.
+func Process(data []byte) (*Processed, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Over)
+
+	out := &Processed{Width: b.Dx(), Height: b.Dy()}
+	// each variant is scaled down from the previous, larger one
+	for _, size := range Sizes {
+		w, h := fit(src.Bounds().Dx(), src.Bounds().Dy(), size.Edge)
+		if w != src.Bounds().Dx() || h != src.Bounds().Dy() {
+			dst := image.NewRGBA(image.Rect(0, 0, w, h))
+			xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), xdraw.Src, nil)
+			src = dst
+		}
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+		out.Variants = append(out.Variants, Variant{
+			Name:   size.Name,
+			Data:   buf.Bytes(),
+			Width:  w,
+			Height: h,
+		})
+	}
+
+	// the smallest variant is plenty for a blurred placeholder
+	out.Blurhash = Blurhash(src)
+	return out, nil
+}
+
+// fit returns the size of a w by h image scaled down for its longest edge
+// to be at most edge.
+func fit(w, h, edge int) (int, int) {
+	if w <= edge && h <= edge {
+		return w, h
+	}
+	if w >= h {
+		return edge, max(1, h*edge/w)
+	}
+	return max(1, w*edge/h), edge
+}
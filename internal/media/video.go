@@ -0,0 +1,211 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+)
+
+// Video metadata is blanked in place rather than cut out: players locate
+// the samples by their offset in the file, which must not move.
+
+// mp4Metadata are the boxes blanked in an MP4: user data, where cameras
+// record the location and device, and metadata item lists.
+var mp4Metadata = map[string]bool{
+	"udta": true,
+	"meta": true,
+}
+
+// mp4Containers are the boxes metadata boxes are found in.
+var mp4Containers = map[string]bool{
+	"moov": true,
+	"trak": true,
+}
+
+// xmpUUID is the extended type of the box holding an XMP packet.
+var xmpUUID = []byte{
+	0xBE, 0x7A, 0xCF, 0xCB, 0x97, 0xA9, 0x42, 0xE8,
+	0x9C, 0x71, 0x99, 0x94, 0x91, 0xE3, 0xAF, 0xAC,
+}
+
+// stripMP4 turns the metadata boxes of an MP4 into zeroed free space.
+func stripMP4(data []byte) ([]byte, error) {
+	if len(data) < 8 || string(data[4:8]) != "ftyp" {
+		return nil, ErrMalformed
+	}
+	out := bytes.Clone(data)
+	if err := blankBoxes(out, 0, len(out)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func blankBoxes(data []byte, start, end int) error {
+	for i := start; i < end; {
+		if i+8 > end {
+			return ErrMalformed
+		}
+		size := uint64(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		header := 8
+		switch size {
+		case 0:
+			// the box runs to the end of the file
+			size = uint64(end - i)
+		case 1:
+			if i+16 > end {
+				return ErrMalformed
+			}
+			size = binary.BigEndian.Uint64(data[i+8:])
+			header = 16
+		}
+		if size < uint64(header) || size > uint64(end-i) {
+			return ErrMalformed
+		}
+		boxEnd := i + int(size)
+
+		xmp := typ == "uuid" && boxEnd-i-header >= len(xmpUUID) &&
+			bytes.Equal(data[i+header:i+header+len(xmpUUID)], xmpUUID)
+		switch {
+		case mp4Metadata[typ] || xmp:
+			copy(data[i+4:], "free")
+			clear(data[i+header : boxEnd])
+		case mp4Containers[typ]:
+			if err := blankBoxes(data, i+header, boxEnd); err != nil {
+				return err
+			}
+		}
+		i = boxEnd
+	}
+	return nil
+}
+
+// EBML element IDs of a WebM.
+const (
+	ebmlHeader      = 0x1A45DFA3
+	ebmlVoid        = 0xEC
+	ebmlSegment     = 0x18538067
+	ebmlInfo        = 0x1549A966
+	ebmlCluster     = 0x1F43B675
+	ebmlTags        = 0x1254C367
+	ebmlAttachments = 0x1941A469
+)
+
+// webmMetadata are the elements blanked in a WebM: tags and attached
+// files, and the title, writing applications and date of the segment.
+var webmMetadata = map[uint32]bool{
+	ebmlTags:        true,
+	ebmlAttachments: true,
+	0x7BA9:          true, // Title
+	0x4D80:          true, // MuxingApp
+	0x5741:          true, // WritingApp
+	0x4461:          true, // DateUTC
+}
+
+// stripWebM turns the metadata elements of a WebM into zeroed Void
+// elements.
+func stripWebM(data []byte) ([]byte, error) {
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != ebmlHeader {
+		return nil, ErrMalformed
+	}
+	out := bytes.Clone(data)
+	if err := blankElements(out, 0, len(out)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func blankElements(data []byte, start, end int) error {
+	for i := start; i < end; {
+		id, idLen, err := ebmlID(data[i:end])
+		if err != nil {
+			return err
+		}
+		size, sizeLen, unknown, err := ebmlVint(data[i+idLen : end])
+		if err != nil {
+			return err
+		}
+		payload := i + idLen + sizeLen
+
+		if unknown {
+			// A live recording does not know the size of its segment and
+			// clusters, they end where the file or the next cluster
+			// starts. Their children are read as if they were siblings,
+			// none of those of a cluster is blanked.
+			if id != ebmlSegment && id != ebmlCluster {
+				return ErrMalformed
+			}
+			i = payload
+			continue
+		}
+		if size > uint64(end-payload) {
+			return ErrMalformed
+		}
+		elemEnd := payload + int(size)
+
+		switch {
+		case webmMetadata[id]:
+			blankElement(data[i:elemEnd])
+		case id == ebmlSegment || id == ebmlInfo:
+			if err := blankElements(data, payload, elemEnd); err != nil {
+				return err
+			}
+		}
+		i = elemEnd
+	}
+	return nil
+}
+
+// blankElement overwrites the element elem with a Void element of the
+// same length.
+func blankElement(elem []byte) {
+	clear(elem)
+	elem[0] = ebmlVoid
+	// the size takes what is left when it fits, so that the payload is
+	// empty, or 8 bytes
+	rest := len(elem) - 1
+	width := min(rest, 8)
+	size := uint64(rest - width)
+	for j := width; j > 0; j-- {
+		elem[j] = byte(size)
+		size >>= 8
+	}
+	elem[1] |= 0x80 >> (width - 1)
+}
+
+// ebmlID reads the ID at the start of data, marker included.
+func ebmlID(data []byte) (uint32, int, error) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, ErrMalformed
+	}
+	n := bits.LeadingZeros8(data[0]) + 1
+	if n > 4 || n > len(data) {
+		return 0, 0, ErrMalformed
+	}
+	var id uint32
+	for _, b := range data[:n] {
+		id = id<<8 | uint32(b)
+	}
+	return id, n, nil
+}
+
+// ebmlVint reads the variable length integer at the start of data. It
+// also reports whether all of its bits are set, which stands for an
+// unknown size.
+func ebmlVint(data []byte) (uint64, int, bool, error) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, false, ErrMalformed
+	}
+	n := bits.LeadingZeros8(data[0]) + 1
+	if n > len(data) {
+		return 0, 0, false, ErrMalformed
+	}
+	mask := byte(0xFF >> n)
+	v := uint64(data[0] & mask)
+	unknown := data[0]&mask == mask
+	for _, b := range data[1:n] {
+		v = v<<8 | uint64(b)
+		unknown = unknown && b == 0xFF
+	}
+	return v, n, unknown, nil
+}
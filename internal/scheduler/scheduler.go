@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrSkipped is returned by a job that had nothing to do this time, such
+// as when another instance holds its lock. The run is logged as skipped.
+var ErrSkipped = errors.New("skipped")
+
+type job struct {
+	name  string
+	every time.Duration
+	run   func(context.Context) error
+}
+
+// Scheduler runs background jobs at fixed intervals. Jobs that must not
+// run on several instances at once are expected to take a lock, such as
+// a Postgres advisory lock, themselves.
+type Scheduler struct {
+	jobs []job
+}
+
+func New() *Scheduler {
+	return &Scheduler{}
+}
+
+// Add registers run to be called every interval, the first time as soon
+// as the scheduler starts.
+func (s *Scheduler) Add(name string, every time.Duration, run func(context.Context) error) {
+	s.jobs = append(s.jobs, job{
+		name:  name,
+		every: every,
+		run:   run,
+	})
+}
+
+// Run starts every job and blocks until ctx is done and the running jobs
+// returned.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.loop(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (j job) loop(ctx context.Context) {
+	ticker := time.NewTicker(j.every)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		err := j.run(ctx)
+		switch {
+		case errors.Is(err, ErrSkipped):
+			log.Printf("job %s: skipped\n", j.name)
+		case err != nil && ctx.Err() == nil:
+			log.Printf("job %s: %v\n", j.name, err.Error())
+		case err == nil:
+			log.Printf("job %s: done in %v\n", j.name, time.Since(start))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// testS3 connects to the bucket described by the S3_* variables of the
+// API, e.g. the MinIO of docker-compose. The test is skipped when
+// S3_ENDPOINT is not set.
+func testS3(t *testing.T) *S3 {
+	t.Helper()
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_ENDPOINT is not set")
+	}
+	cfg := S3Config{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("S3_REGION"),
+		Bucket:    os.Getenv("S3_BUCKET"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Bucket == "" {
+		cfg.Bucket = "media"
+	}
+	return NewS3(cfg)
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	s := testS3(t)
+	ctx := context.Background()
+	key := fmt.Sprintf("test/%d/blob.bin", time.Now().UnixNano())
+	data := bytes.Repeat([]byte("blob "), 4096)
+
+	if err := s.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "application/octet-stream"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	t.Cleanup(func() { s.Delete(context.Background(), key) })
+
+	rc, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Get returned %d bytes, want the %d put", len(got), len(data))
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing blob = %v, want nil", err)
+	}
+}
+
+func TestS3GetMissing(t *testing.T) {
+	s := testS3(t)
+
+	_, err := s.Get(context.Background(), "test/missing/blob.bin")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestS3RejectsInvalidKey(t *testing.T) {
+	s := NewS3(S3Config{Endpoint: "http://localhost:9000", Bucket: "media"})
+
+	for _, key := range []string{"", "../escape", "/absolute"} {
+		if err := s.Put(context.Background(), key, bytes.NewReader(nil), 0, "text/plain"); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+	}
+}
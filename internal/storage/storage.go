@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// BlobStore keeps binary objects by key. Keys are slash separated paths
+// made of letters, digits, dots, dashes and underscores.
+type BlobStore interface {
+	// Put stores size bytes read from r under key, replacing any object
+	// already stored there.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key, it returns ErrNotFound when
+	// there is none. The caller closes the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key, deleting a missing
+	// object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// checkKey tells whether key is a valid blob key, keys never leave the
+// directory or bucket they are stored in.
+func checkKey(key string) error {
+	if key == "" || len(key) > 512 {
+		return ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrInvalidKey
+		}
+		for _, c := range part {
+			ok := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+				c == '.' || c == '-' || c == '_'
+			if !ok {
+				return ErrInvalidKey
+			}
+		}
+	}
+	return nil
+}
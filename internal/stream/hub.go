@@ -0,0 +1,106 @@
+package stream
+
+import (
+	"sync"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+)
+
+// Subscription receives the events of one user on C. C is closed when the
+// subscription falls too far behind, the client is then expected to
+// reconnect and catch up from the sequence number of the last event it
+// received.
+type Subscription struct {
+	C      chan database.StreamEvent
+	userID int64
+	hub    *Hub
+	closed bool
+}
+
+// Hub fans events out to the users connected to this instance.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[int64]map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subs: make(map[int64]map[*Subscription]struct{}),
+	}
+}
+
+func (h *Hub) Subscribe(userID int64) *Subscription {
+	sub := &Subscription{
+		C:      make(chan database.StreamEvent, 64),
+		userID: userID,
+		hub:    h,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[*Subscription]struct{})
+	}
+	h.subs[userID][sub] = struct{}{}
+	return sub
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// remove must be called with h.mu held.
+func (h *Hub) remove(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.C)
+
+	delete(h.subs[sub.userID], sub)
+	if len(h.subs[sub.userID]) == 0 {
+		delete(h.subs, sub.userID)
+	}
+}
+
+// Publish hands an event to every subscription of its user without
+// blocking; subscriptions that can not keep up are closed.
+func (h *Hub) Publish(ev database.StreamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs[ev.UserID] {
+		select {
+		case sub.C <- ev:
+		default:
+			h.remove(sub)
+		}
+	}
+}
+
+// Connected returns the ids of the users with at least one subscription.
+func (h *Hub) Connected() []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ids := make([]int64, 0, len(h.subs))
+	for id := range h.subs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Reset closes every subscription. It is used when events may have been
+// missed, clients reconnect and catch up from their last sequence number.
+func (h *Hub) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, subs := range h.subs {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
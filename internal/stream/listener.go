@@ -0,0 +1,96 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Alter-Sitanshu/learning_Go/internal/database"
+	"github.com/lib/pq"
+)
+
+// Retention is how long events are kept for clients to catch up on.
+const Retention = 24 * time.Hour
+
+// Listen relays the events announced on database.StreamChannel, by any
+// instance, to the users connected to this hub. It blocks until ctx is done.
+func (h *Hub) Listen(ctx context.Context, dsn string, store database.StreamInterface) error {
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("stream listener: %v\n", err.Error())
+			}
+		})
+	defer listener.Close()
+
+	if err := listener.Listen(database.StreamChannel); err != nil {
+		return err
+	}
+
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.Reset()
+			return nil
+
+		case n := <-listener.Notify:
+			// A nil notification means the connection was re-established
+			// and announcements may have been lost in between.
+			if n == nil {
+				h.Reset()
+				continue
+			}
+			h.relay(ctx, store, n.Extra)
+
+		case <-ping.C:
+			go listener.Ping()
+
+		case <-prune.C:
+			if err := store.Prune(ctx, time.Now().Add(-Retention)); err != nil {
+				log.Printf("stream prune: %v\n", err.Error())
+			}
+		}
+	}
+}
+
+func (h *Hub) relay(ctx context.Context, store database.StreamInterface, payload string) {
+	var notice database.StreamNotice
+	if err := json.Unmarshal([]byte(payload), &notice); err != nil {
+		log.Printf("stream listener: bad payload %q\n", payload)
+		return
+	}
+
+	if notice.Transient != nil {
+		for _, userID := range notice.Recipients {
+			ev := *notice.Transient
+			ev.UserID = userID
+			h.Publish(ev)
+		}
+		return
+	}
+
+	users := h.Connected()
+	if len(users) == 0 {
+		return
+	}
+	var events []database.StreamEvent
+	var err error
+	if notice.Post != 0 {
+		events, err = store.PostEvents(ctx, notice.Post, notice.Author, users)
+	} else {
+		events, err = store.Between(ctx, notice, users)
+	}
+	if err != nil {
+		log.Printf("stream listener: %v\n", err.Error())
+		return
+	}
+	for _, ev := range events {
+		h.Publish(ev)
+	}
+}
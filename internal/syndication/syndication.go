@@ -0,0 +1,184 @@
+package syndication
+
+import (
+	"bytes"
+	"encoding/xml"
+	"time"
+)
+
+// Item is an entry of a feed. ID must be unique and never change, it is
+// the guid of RSS and the id of Atom. Content is HTML.
+type Item struct {
+	ID         string
+	Title      string
+	Link       string
+	Author     string
+	Content    string
+	Enclosures []Enclosure
+	Published  time.Time
+	Updated    time.Time
+}
+
+// Enclosure is a file attached to an item, Length is its size in bytes.
+type Enclosure struct {
+	URL    string
+	Type   string
+	Length int64
+}
+
+// Channel is a feed, Self is the address it is served at.
+type Channel struct {
+	Title       string
+	Description string
+	Link        string
+	Self        string
+	Updated     time.Time
+	Items       []Item
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        rssGUID       `xml:"guid"`
+	Creator     string        `xml:"dc:creator"`
+	Description string        `xml:"description"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+	PubDate     string        `xml:"pubDate"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Links     []atomLink  `xml:"link"`
+	Author    atomAuthor  `xml:"author"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// RSS renders c as an RSS 2.0 document. RSS expects an e-mail address in
+// author, the name of the author is given in dc:creator instead, and
+// readers only take the first enclosure of an item.
+func (c *Channel) RSS() ([]byte, error) {
+	doc := rss{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         c.Title,
+			Link:          c.Link,
+			Description:   c.Description,
+			Self:          atomLink{Href: c.Self, Rel: "self", Type: "application/rss+xml"},
+			LastBuildDate: c.Updated.UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, item := range c.Items {
+		ri := rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			GUID:        rssGUID{IsPermaLink: item.ID == item.Link, Value: item.ID},
+			Creator:     item.Author,
+			Description: item.Content,
+			PubDate:     item.Published.UTC().Format(time.RFC1123Z),
+		}
+		if len(item.Enclosures) > 0 {
+			e := item.Enclosures[0]
+			ri.Enclosure = &rssEnclosure{URL: e.URL, Length: e.Length, Type: e.Type}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, ri)
+	}
+	return render(doc)
+}
+
+// Atom renders c as an Atom 1.0 document.
+func (c *Channel) Atom() ([]byte, error) {
+	doc := atomFeed{
+		ID:      c.Self,
+		Title:   c.Title,
+		Updated: c.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: c.Link},
+			{Href: c.Self, Rel: "self", Type: "application/atom+xml"},
+		},
+	}
+	for _, item := range c.Items {
+		links := []atomLink{{Href: item.Link}}
+		for _, e := range item.Enclosures {
+			links = append(links, atomLink{Href: e.URL, Rel: "enclosure", Type: e.Type, Length: e.Length})
+		}
+		doc.Entries = append(doc.Entries, atomEntry{
+			ID:        item.ID,
+			Title:     item.Title,
+			Links:     links,
+			Author:    atomAuthor{Name: item.Author},
+			Published: item.Published.UTC().Format(time.RFC3339),
+			Updated:   item.Updated.UTC().Format(time.RFC3339),
+			Content:   atomContent{Type: "html", Value: item.Content},
+		})
+	}
+	return render(doc)
+}
+
+func render(doc any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,138 @@
+package unfurl
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Longest title and description kept, in runes.
+const (
+	maxTitle       = 300
+	maxDescription = 1000
+)
+
+// parse reads the head of the page at base. OpenGraph tags win over
+// Twitter Card tags, which win over the title and description of the
+// page.
+func parse(r io.Reader, base *url.URL) *Preview {
+	var og, twitter, page Preview
+	z := html.NewTokenizer(r)
+	inTitle := false
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return merge(base, og, twitter, page)
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Head:
+				return merge(base, og, twitter, page)
+			case atom.Title:
+				inTitle = false
+			}
+		case html.TextToken:
+			if inTitle && page.Title == "" {
+				page.Title = string(z.Text())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Body:
+				return merge(base, og, twitter, page)
+			case atom.Title:
+				inTitle = tt == html.StartTagToken
+			case atom.Meta:
+				if !hasAttr {
+					continue
+				}
+				var key, content string
+				for {
+					k, v, more := z.TagAttr()
+					switch string(k) {
+					case "property", "name":
+						if key == "" {
+							key = strings.ToLower(string(v))
+						}
+					case "content":
+						content = string(v)
+					}
+					if !more {
+						break
+					}
+				}
+				setMeta(&og, &twitter, &page, key, content)
+			}
+		}
+	}
+}
+
+func setMeta(og, twitter, page *Preview, key, content string) {
+	fields := map[string]*string{
+		"og:title":            &og.Title,
+		"og:description":      &og.Description,
+		"og:image":            &og.Image,
+		"og:image:url":        &og.Image,
+		"og:image:secure_url": &og.Image,
+		"og:site_name":        &og.SiteName,
+		"twitter:title":       &twitter.Title,
+		"twitter:description": &twitter.Description,
+		"twitter:image":       &twitter.Image,
+		"twitter:image:src":   &twitter.Image,
+		"description":         &page.Description,
+	}
+	if field, ok := fields[key]; ok && *field == "" {
+		*field = content
+	}
+}
+
+func merge(base *url.URL, previews ...Preview) *Preview {
+	out := &Preview{}
+	for _, p := range previews {
+		out.Title = first(out.Title, p.Title)
+		out.Description = first(out.Description, p.Description)
+		out.Image = first(out.Image, p.Image)
+		out.SiteName = first(out.SiteName, p.SiteName)
+	}
+	out.Title = clean(out.Title, maxTitle)
+	out.Description = clean(out.Description, maxDescription)
+	out.SiteName = clean(out.SiteName, maxTitle)
+	out.Image = resolve(base, out.Image)
+	return out
+}
+
+func first(a, b string) string {
+	if strings.TrimSpace(a) != "" {
+		return a
+	}
+	return b
+}
+
+// clean collapses the white space of s and cuts it to max runes.
+func clean(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max-1]) + "…"
+}
+
+// resolve returns the absolute address of the image ref of the page at
+// base, images are only linked to over http and https.
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || checkURL(u) != nil {
+		return ""
+	}
+	return u.String()
+}
@@ -0,0 +1,165 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrForbidden = errors.New("address is not public")
+	ErrNotHTML   = errors.New("not an HTML page")
+	ErrNoPreview = errors.New("page has no preview metadata")
+)
+
+// Preview is what a page tells of itself through OpenGraph and Twitter
+// Card tags, URL is the page the preview was fetched from.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	Image       string
+	SiteName    string
+}
+
+// Config bounds the fetching of pages. Allow tells which addresses may be
+// connected to, it is Public unless set.
+type Config struct {
+	Timeout      time.Duration
+	MaxBytes     int64
+	MaxRedirects int
+	UserAgent    string
+	Allow        func(netip.Addr) bool
+}
+
+// DefaultConfig fetches at most 512kB of a page within 5 seconds.
+var DefaultConfig = Config{
+	Timeout:      time.Second * 5,
+	MaxBytes:     512 << 10,
+	MaxRedirects: 3,
+	UserAgent:    "GOSocialBot/1.0 (+link previews)",
+}
+
+// Fetcher retrieves the previews of pages.
+type Fetcher struct {
+	cfg    Config
+	allow  func(netip.Addr) bool
+	client *http.Client
+}
+
+func NewFetcher(cfg Config) *Fetcher {
+	f := &Fetcher{cfg: cfg, allow: cfg.Allow}
+	if f.allow == nil {
+		f.allow = Public
+	}
+
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+		Control: f.control,
+	}
+	f.client = &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			// a proxy would connect on our behalf, past the address checks
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   cfg.Timeout,
+			ResponseHeaderTimeout: cfg.Timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return errors.New("too many redirects")
+			}
+			return checkURL(req.URL)
+		},
+	}
+	return f
+}
+
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" || u.User != nil {
+		return errors.New("invalid address")
+	}
+	return nil
+}
+
+// Fetch retrieves the page at rawURL and returns its preview. Only HTML
+// pages are read, up to MaxBytes, and only from addresses Allow accepts,
+// redirects included.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.cfg.UserAgent)
+	req.Header.Set("Accept", "text/html")
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", u, res.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	preview := parse(io.LimitReader(res.Body, f.cfg.MaxBytes), res.Request.URL)
+	if preview.Title == "" && preview.Description == "" && preview.Image == "" {
+		return nil, ErrNoPreview
+	}
+	preview.URL = rawURL
+	return preview, nil
+}
+
+// MaxURLs is how many links of a text are previewed.
+const MaxURLs = 4
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"'\x60]+`)
+
+// ExtractURLs returns the distinct http and https links of text, in order
+// and at most MaxURLs of them. Punctuation ending a sentence is not taken
+// as part of a link.
+func ExtractURLs(text string) []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, raw := range urlPattern.FindAllString(text, -1) {
+		raw = strings.TrimRight(raw, ".,;:!?)]}*_~")
+		u, err := url.Parse(raw)
+		if err != nil || checkURL(u) != nil || seen[raw] {
+			continue
+		}
+		seen[raw] = true
+		urls = append(urls, raw)
+		if len(urls) == MaxURLs {
+			break
+		}
+	}
+	return urls
+}
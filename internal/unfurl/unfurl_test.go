@@ -0,0 +1,255 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loopback lets the tests fetch from their own servers.
+func loopback(addr netip.Addr) bool { return addr.Unmap().IsLoopback() }
+
+func testFetcher(cfg Config) *Fetcher {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = DefaultConfig.MaxBytes
+	}
+	if cfg.MaxRedirects == 0 {
+		cfg.MaxRedirects = DefaultConfig.MaxRedirects
+	}
+	if cfg.Allow == nil {
+		cfg.Allow = loopback
+	}
+	return NewFetcher(cfg)
+}
+
+func servePage(t *testing.T, contentType, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchPriority(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want Preview
+	}{
+		{
+			name: "opengraph beats twitter and title",
+			head: `<title>Page title</title>
+				<meta name="description" content="Page description">
+				<meta name="twitter:title" content="Twitter title">
+				<meta name="twitter:description" content="Twitter description">
+				<meta property="og:title" content="OG title">
+				<meta property="og:description" content="OG description">`,
+			want: Preview{Title: "OG title", Description: "OG description"},
+		},
+		{
+			name: "twitter beats title",
+			head: `<title>Page title</title>
+				<meta name="description" content="Page description">
+				<meta name="twitter:title" content="Twitter title">
+				<meta name="twitter:description" content="Twitter description">`,
+			want: Preview{Title: "Twitter title", Description: "Twitter description"},
+		},
+		{
+			name: "title and description",
+			head: `<title>Page title</title>
+				<meta name="description" content="Page description">`,
+			want: Preview{Title: "Page title", Description: "Page description"},
+		},
+		{
+			name: "fields fall back one by one",
+			head: `<title>Page title</title>
+				<meta name="twitter:description" content="Twitter description">
+				<meta property="og:site_name" content="Site">`,
+			want: Preview{Title: "Page title", Description: "Twitter description", SiteName: "Site"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := servePage(t, "text/html; charset=utf-8",
+				"<!doctype html><html><head>"+tt.head+"</head><body>body</body></html>")
+
+			got, err := testFetcher(Config{}).Fetch(context.Background(), srv.URL)
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			tt.want.URL = srv.URL
+			if *got != tt.want {
+				t.Errorf("Fetch = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchResolvesImage(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/articles/one", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head>
+			<meta property="og:title" content="One">
+			<meta property="og:image" content="../img/cover.png">
+			</head></html>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	got, err := testFetcher(Config{}).Fetch(context.Background(), srv.URL+"/articles/one")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if want := srv.URL + "/img/cover.png"; got.Image != want {
+		t.Errorf("Image = %q, want %q", got.Image, want)
+	}
+}
+
+func TestFetchRejectsNonHTML(t *testing.T) {
+	srv := servePage(t, "application/json", `{"title": "not a page"}`)
+
+	_, err := testFetcher(Config{}).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrNotHTML) {
+		t.Errorf("Fetch = %v, want %v", err, ErrNotHTML)
+	}
+}
+
+func TestFetchMaxBytes(t *testing.T) {
+	padding := "<!--" + strings.Repeat("x", 4096) + "-->"
+	srv := servePage(t, "text/html",
+		"<html><head>"+padding+`<meta property="og:title" content="Late"></head></html>`)
+
+	_, err := testFetcher(Config{MaxBytes: 1024}).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrNoPreview) {
+		t.Errorf("Fetch past MaxBytes = %v, want %v", err, ErrNoPreview)
+	}
+
+	got, err := testFetcher(Config{MaxBytes: 8192}).Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Fetch within MaxBytes: %v", err)
+	}
+	if got.Title != "Late" {
+		t.Errorf("Title = %q, want %q", got.Title, "Late")
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	start := time.Now()
+	_, err := testFetcher(Config{Timeout: 100 * time.Millisecond}).Fetch(context.Background(), srv.URL)
+	if err == nil {
+		t.Fatal("Fetch of a stalled page succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Fetch gave up after %s", elapsed)
+	}
+}
+
+func TestFetchRejectsLoopback(t *testing.T) {
+	srv := servePage(t, "text/html", `<html><head><title>internal</title></head></html>`)
+
+	_, err := NewFetcher(DefaultConfig).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("Fetch = %v, want %v", err, ErrForbidden)
+	}
+}
+
+func TestFetchRejectsRedirectToLoopback(t *testing.T) {
+	internal := servePage(t, "text/html", `<html><head><title>internal</title></head></html>`)
+
+	// The page redirecting is served from 127.0.0.2, admitted on top of
+	// Public, the target on 127.0.0.1 is left for Public to judge.
+	ln, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("listen on 127.0.0.2: %v", err)
+	}
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusFound)
+	}))
+	origin.Listener.Close()
+	origin.Listener = ln
+	origin.Start()
+	defer origin.Close()
+
+	originAddr := netip.MustParseAddr("127.0.0.2")
+	cfg := DefaultConfig
+	cfg.Allow = func(addr netip.Addr) bool { return addr == originAddr || Public(addr) }
+
+	_, err = NewFetcher(cfg).Fetch(context.Background(), origin.URL)
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("Fetch = %v, want %v", err, ErrForbidden)
+	}
+}
+
+func TestPublic(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"93.184.216.34", true},
+		{"2606:4700:4700::1111", true},
+		{"10.0.0.1", false},
+		{"10.255.255.255", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"169.254.0.1", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"fc00::1", false},
+		{"fd12:3456:789a::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+		{"2001:db8::1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := Public(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("Public(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	text := "see https://a.example/x. and (http://b.example/y) " +
+		"again https://a.example/x, ftp://c.example https://d.example https://e.example https://f.example"
+	want := []string{"https://a.example/x", "http://b.example/y", "https://d.example", "https://e.example"}
+
+	got := ExtractURLs(text)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ExtractURLs = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+type task struct {
+	name string
+	run  func(context.Context) error
+}
+
+// Pool runs tasks submitted by request handlers on a fixed number of
+// goroutines so that the work is done after the response was sent.
+type Pool struct {
+	size  int
+	tasks chan task
+}
+
+// NewPool returns a pool of size workers sharing a queue of at most queue
+// pending tasks.
+func NewPool(size, queue int) *Pool {
+	return &Pool{
+		size:  size,
+		tasks: make(chan task, queue),
+	}
+}
+
+// Submit queues run without blocking, it reports false when the queue is
+// full and the task was dropped.
+func (p *Pool) Submit(name string, run func(context.Context) error) bool {
+	select {
+	case p.tasks <- task{name: name, run: run}:
+		return true
+	default:
+		log.Printf("worker queue full, dropped task %s\n", name)
+		return false
+	}
+}
+
+// Run starts the workers and blocks until ctx is done and the running
+// tasks returned. Tasks still queued at that point are dropped.
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range p.size {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-p.tasks:
+			if err := t.run(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("task %s: %v\n", t.name, err.Error())
+			}
+		}
+	}
+}